@@ -7,25 +7,30 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/kpiljoong/flox/internal/config"
-	"github.com/kpiljoong/flox/internal/filters"
-	"github.com/kpiljoong/flox/internal/input"
-	"github.com/kpiljoong/flox/internal/input/file"
 	"github.com/kpiljoong/flox/internal/metrics"
-	"github.com/kpiljoong/flox/internal/output"
+	"github.com/kpiljoong/flox/internal/pipeline"
 )
 
-var cfgFile string
+var (
+	cfgFile         string
+	shutdownTimeout time.Duration
+)
 
 var rootCmd = &cobra.Command{
 	Use:   "flox",
 	Short: "Flox is a fast and programmable log/event processor",
 	Run: func(cmd *cobra.Command, args []string) {
+		// Inputs stop on the first signal; outputs keep running until they
+		// are drained so queued events are not lost.
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
+		outCtx, cancelOutputs := context.WithCancel(context.Background())
+		defer cancelOutputs()
 
 		// Setup signal handler for graceful shutdown
 		sigCh := make(chan os.Signal, 1)
@@ -34,10 +39,13 @@ var rootCmd = &cobra.Command{
 			<-sigCh
 			fmt.Println("\n[signal] Shutting down gracefully...")
 			cancel()
+			<-sigCh
+			fmt.Println("\n[signal] Forcing shutdown...")
+			cancelOutputs()
 		}()
 
 		// Load configuration
-		cfg, rawCfg, err := config.Load(cfgFile)
+		cfg, _, err := config.Load(cfgFile)
 		if err != nil {
 			fmt.Printf("Error loading config: %v\n", err)
 			os.Exit(1)
@@ -46,82 +54,78 @@ var rootCmd = &cobra.Command{
 		// Initialize metrics server
 		metrics.InitMetricsServer()
 
-		jsonFilters := setupFilters(cfg.Filters)
-
-		// Setup output
-		out, err := setupOutput(ctx, cfg.Output.Type, rawCfg)
-		if err != nil {
-			fmt.Printf("Error setting up output: %v\n", err)
+		// Setup and start pipelines
+		manager := pipeline.NewManager(ctx, outCtx)
+		if err := manager.Apply(cfg.AllPipelines()); err != nil {
+			fmt.Printf("Error setting up pipelines: %v\n", err)
 			os.Exit(1)
 		}
 
-		// Input handler
-		handler := buildHandler(ctx, jsonFilters, out)
-
-		// Setup input
-		switch cfg.Input.Type {
-		case "http":
-			input.StartHTTP(cfg.Input.Address, handler)
-		case "file":
-			file.StartFile(ctx, cfg.Input.Path, handler, cfg.Input.Namespace, cfg.Input.TrackOffset, cfg.Input.StartFrom)
-		default:
-			log.Fatalf("Unsupported input type: %s", cfg.Input.Type)
+		// Reload on SIGHUP or when the config file changes
+		reloadCh := make(chan struct{}, 1)
+		requestReload := func() {
+			select {
+			case reloadCh <- struct{}{}:
+			default:
+			}
 		}
+		hupCh := make(chan os.Signal, 1)
+		signal.Notify(hupCh, syscall.SIGHUP)
+		go func() {
+			for range hupCh {
+				fmt.Println("[signal] SIGHUP received, reloading config...")
+				requestReload()
+			}
+		}()
+		if err := config.Watch(ctx, cfgFile, requestReload); err != nil {
+			fmt.Printf("[reload] Not watching %s for changes: %v\n", cfgFile, err)
+		}
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-reloadCh:
+					reloadConfig(manager)
+				}
+			}
+		}()
 
 		// Wait for shutdown signal
 		<-ctx.Done()
+		manager.Wait()
+		fmt.Println("[shutdown] Draining outputs...")
+		if !manager.Drain(shutdownTimeout, cancelOutputs) {
+			fmt.Printf("[shutdown] Drain did not finish within %s, dropping remaining events\n", shutdownTimeout)
+		}
 		fmt.Println("[shutdown] Flox stopped.")
 	},
 }
 
-func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		log.Fatal(err)
-		os.Exit(1)
-	}
-}
-
-func setupFilters(filterConfigs []config.FilterConfig) []*filters.JSONFilter {
-	var jsonFilters []*filters.JSONFilter
-	for _, f := range filterConfigs {
-		if f.Type == "json" {
-			jsonFilters = append(jsonFilters, filters.NewJSONFilter(f.DropFields, f.RenameFields, f.AddFields))
-		}
+// reloadConfig applies the current config file. An invalid config is
+// rejected as a whole and the running pipelines are left untouched.
+func reloadConfig(manager *pipeline.Manager) {
+	cfg, _, err := config.Load(cfgFile)
+	if err == nil {
+		err = manager.Apply(cfg.AllPipelines())
 	}
-	return jsonFilters
-}
-
-func setupOutput(ctx context.Context, outputType string, rawCfg map[string]interface{}) (output.Output, error) {
-	fmt.Printf("Creating output: %s...\n", outputType)
-	outputConfigRaw, ok := rawCfg["output"].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid output config")
+	if err != nil {
+		fmt.Printf("[reload] Rejected new config, keeping current pipelines: %v\n", err)
+		metrics.ConfigReloads.WithLabelValues("failure").Inc()
+		return
 	}
-	return output.NewOutput(ctx, outputType, outputConfigRaw)
+	fmt.Println("[reload] Config reloaded")
+	metrics.ConfigReloads.WithLabelValues("success").Inc()
 }
 
-func buildHandler(ctx context.Context, filters []*filters.JSONFilter, out output.Output) func(map[string]interface{}) {
-	return func(event map[string]interface{}) {
-		// log.Printf("[Processing] Received event: %v\n", event)
-		metrics.EventReceived.Inc()
-
-		for _, f := range filters {
-			event = f.Process(event)
-			metrics.EventFiltered.Inc()
-		}
-
-		if err := out.Send(event); err != nil {
-			if ctx.Err() != nil {
-				return
-			}
-			fmt.Printf("Error sending event: %v\n", err)
-			metrics.OutputFailure.Inc()
-		} else {
-			metrics.OutputSuccess.Inc()
-		}
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		log.Fatal(err)
+		os.Exit(1)
 	}
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "pipeline.yaml", "config file (default is pipeline.yaml)")
+	rootCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "maximum time to drain outputs on shutdown")
 }
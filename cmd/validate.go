@@ -3,11 +3,12 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"sort"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 
 	"github.com/kpiljoong/flox/internal/config"
+	"github.com/kpiljoong/flox/internal/pipeline"
 )
 
 var validateCmd = &cobra.Command{
@@ -18,27 +19,29 @@ var validateCmd = &cobra.Command{
 		cfgFile := args[0]
 		fmt.Printf("Validating pipeline config: %s\n", cfgFile)
 
-		v := viper.New()
-		v.SetConfigFile(cfgFile)
-		if err := v.ReadInConfig(); err != nil {
-			fmt.Printf("Error reading config file: %s\n", err)
+		cfg, _, err := config.Load(cfgFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse config: %v\n", err)
 			os.Exit(1)
 		}
 
-		var pipeline config.PipelineConfig
-		if err := v.Unmarshal(&pipeline); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to parse config: %v\n", err)
+		pipelines := cfg.AllPipelines()
+		if len(pipelines) == 0 {
+			fmt.Fprintln(os.Stderr, "'input' or 'pipelines' is required")
 			os.Exit(1)
 		}
 
-		// Basic validation
-		if pipeline.Input.Type == "" {
-			fmt.Fprintln(os.Stderr, "'input.type' is required")
-			os.Exit(1)
+		names := make([]string, 0, len(pipelines))
+		for name := range pipelines {
+			names = append(names, name)
 		}
-		if pipeline.Output.Type == "" {
-			fmt.Fprintln(os.Stderr, "'output.type' is required")
-			os.Exit(1)
+		sort.Strings(names)
+
+		for _, name := range names {
+			if err := pipeline.Validate(pipelines[name]); err != nil {
+				fmt.Fprintf(os.Stderr, "pipeline %s: %v\n", name, err)
+				os.Exit(1)
+			}
 		}
 		fmt.Println("Config is valid")
 	},
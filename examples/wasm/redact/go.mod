@@ -0,0 +1,3 @@
+module github.com/kpiljoong/flox/examples/wasm/redact
+
+go 1.24
@@ -0,0 +1,73 @@
+//go:build wasip1
+
+// Command redact is an example Flox wasm filter. It drops debug events,
+// splits events that carry a "records" list into one event per record and
+// masks the "password" field of everything else.
+//
+// Build it with Go 1.24 or later:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o redact.wasm .
+package main
+
+import (
+	"encoding/json"
+	"unsafe"
+)
+
+// input holds the buffer handed out by flox_alloc until flox_filter runs.
+var input []byte
+
+// output keeps the result alive until the host has read it.
+var output []byte
+
+func main() {}
+
+//go:wasmexport flox_alloc
+func floxAlloc(size uint32) unsafe.Pointer {
+	input = make([]byte, size)
+	if size == 0 {
+		return nil
+	}
+	return unsafe.Pointer(&input[0])
+}
+
+//go:wasmexport flox_filter
+func floxFilter(ptr unsafe.Pointer, size uint32) uint64 {
+	var event map[string]interface{}
+	if err := json.Unmarshal(unsafe.Slice((*byte)(ptr), size), &event); err != nil {
+		panic(err)
+	}
+
+	if event["level"] == "debug" {
+		return 0
+	}
+
+	var result interface{} = redact(event)
+	if records, ok := event["records"].([]interface{}); ok {
+		delete(event, "records")
+		events := make([]map[string]interface{}, 0, len(records))
+		for _, r := range records {
+			out := make(map[string]interface{}, len(event)+1)
+			for k, v := range event {
+				out[k] = v
+			}
+			out["record"] = r
+			events = append(events, redact(out))
+		}
+		result = events
+	}
+
+	var err error
+	output, err = json.Marshal(result)
+	if err != nil {
+		panic(err)
+	}
+	return uint64(uintptr(unsafe.Pointer(&output[0])))<<32 | uint64(len(output))
+}
+
+func redact(event map[string]interface{}) map[string]interface{} {
+	if _, ok := event["password"]; ok {
+		event["password"] = "***"
+	}
+	return event
+}
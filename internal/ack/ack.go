@@ -0,0 +1,63 @@
+package ack
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Token travels with an event from its input to the outputs. Every stage
+// that takes over responsibility for the event holds a reference, and the
+// input is told the outcome once all references are resolved.
+//
+// A nil *Token is valid and ignores all calls, so stages never need to check
+// whether their caller asked for acknowledgements.
+type Token struct {
+	refs   atomic.Int32
+	failed atomic.Bool
+	once   sync.Once
+	done   func(ok bool)
+}
+
+// New returns a token holding a single reference. done is called exactly
+// once, with ok set to false if any reference was nacked.
+func New(done func(ok bool)) *Token {
+	t := &Token{done: done}
+	t.refs.Store(1)
+	return t
+}
+
+// Retain adds n references, for example when an event is fanned out to
+// several outputs. It must be called before the current reference is
+// released.
+func (t *Token) Retain(n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+	t.refs.Add(int32(n))
+}
+
+// Ack releases one reference after successful delivery.
+func (t *Token) Ack() {
+	t.release(true)
+}
+
+// Nack releases one reference after a failed delivery.
+func (t *Token) Nack() {
+	t.release(false)
+}
+
+func (t *Token) release(ok bool) {
+	if t == nil {
+		return
+	}
+	if !ok {
+		t.failed.Store(true)
+	}
+	if t.refs.Add(-1) == 0 {
+		t.once.Do(func() {
+			if t.done != nil {
+				t.done(!t.failed.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package ack_test
+
+import (
+	"testing"
+
+	"github.com/kpiljoong/flox/internal/ack"
+)
+
+func TestToken_ResolvesAfterAllReferences(t *testing.T) {
+	var calls int
+	var result bool
+	tok := ack.New(func(ok bool) {
+		calls++
+		result = ok
+	})
+	tok.Retain(2)
+
+	tok.Ack()
+	tok.Ack()
+	if calls != 0 {
+		t.Fatal("token resolved before all references were released")
+	}
+	tok.Ack()
+
+	if calls != 1 || !result {
+		t.Errorf("expected one successful resolution, got calls=%d ok=%v", calls, result)
+	}
+}
+
+func TestToken_NackFailsToken(t *testing.T) {
+	var result = true
+	tok := ack.New(func(ok bool) { result = ok })
+	tok.Retain(1)
+
+	tok.Nack()
+	tok.Ack()
+
+	if result {
+		t.Error("expected token to resolve as failed after a nack")
+	}
+}
+
+func TestToken_NilIsNoop(t *testing.T) {
+	var tok *ack.Token
+	tok.Retain(1)
+	tok.Ack()
+	tok.Nack()
+}
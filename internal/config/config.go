@@ -2,49 +2,261 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
+// Config is the top-level configuration. A file either describes a single
+// pipeline at the top level, named "default", or several under `pipelines:`.
+type Config struct {
+	PipelineConfig `mapstructure:",squash"`
+	Pipelines      map[string]PipelineConfig `mapstructure:"pipelines"`
+}
+
+const DefaultPipelineName = "default"
+
+// AllPipelines returns every configured pipeline by name.
+func (c *Config) AllPipelines() map[string]PipelineConfig {
+	pipelines := make(map[string]PipelineConfig, len(c.Pipelines)+1)
+	for name, p := range c.Pipelines {
+		pipelines[name] = p
+	}
+	if len(c.AllInputs()) > 0 || len(c.AllOutputs()) > 0 {
+		pipelines[DefaultPipelineName] = c.PipelineConfig
+	}
+	return pipelines
+}
+
 type PipelineConfig struct {
 	Input   InputConfig    `mapstructure:"input"`
+	Inputs  []InputConfig  `mapstructure:"inputs"`
 	Filters []FilterConfig `mapstructure:"filters"`
 	Output  OutputConfig   `mapstructure:"output"`
+	Outputs []OutputConfig `mapstructure:"outputs"`
+	Routing RoutingConfig  `mapstructure:"routing"`
 }
 
 type InputConfig struct {
+	ID          string `mapstructure:"id"`
 	Type        string `mapstructure:"type"`
 	Namespace   string `mapstructure:"namespace"`
 	Address     string `mapstructure:"address"`
 	Path        string `mapstructure:"path"`
 	TrackOffset bool   `mapstructure:"track_offset"`
 	StartFrom   string `mapstructure:"start_from"`
+
+	// Settings holds the keys of other input types.
+	Settings map[string]interface{} `mapstructure:",remain"`
+}
+
+// Options returns the type-specific settings passed to input.NewInput.
+func (i InputConfig) Options() map[string]interface{} {
+	opts := make(map[string]interface{}, len(i.Settings)+5)
+	for k, v := range i.Settings {
+		opts[k] = v
+	}
+	if i.Namespace != "" {
+		opts["namespace"] = i.Namespace
+	}
+	if i.Address != "" {
+		opts["address"] = i.Address
+	}
+	if i.Path != "" {
+		opts["path"] = i.Path
+	}
+	if i.TrackOffset {
+		opts["track_offset"] = i.TrackOffset
+	}
+	if i.StartFrom != "" {
+		opts["start_from"] = i.StartFrom
+	}
+	return opts
 }
 
 type OutputConfig struct {
-	Type   string `mapstructure:"type"`
-	Target string `mapstructure:"target"`
+	Name   string       `mapstructure:"name"`
+	Type   string       `mapstructure:"type"`
+	Target string       `mapstructure:"target"`
+	Queue  QueueConfig  `mapstructure:"queue"`
+	Batch  BatchConfig  `mapstructure:"batch"`
+	Buffer BufferConfig `mapstructure:"buffer"`
+
+	// Settings holds the type-specific keys (labels, brokers, ...).
+	Settings map[string]interface{} `mapstructure:",remain"`
+}
+
+// Options returns the type-specific settings passed to output.NewOutput.
+func (o OutputConfig) Options() map[string]interface{} {
+	opts := make(map[string]interface{}, len(o.Settings)+1)
+	for k, v := range o.Settings {
+		opts[k] = v
+	}
+	if o.Target != "" {
+		opts["target"] = o.Target
+	}
+	return opts
+}
+
+// QueueConfig controls the in-memory queue placed in front of an output.
+// Zero values fall back to the defaults in the output package.
+type QueueConfig struct {
+	Capacity int    `mapstructure:"capacity"`
+	Workers  int    `mapstructure:"workers"`
+	WhenFull string `mapstructure:"when_full"` // block, drop_newest or drop_oldest
+}
+
+// BatchConfig groups queued events into a single SendBatch call of up to Size
+// events, waiting at most Linger (e.g. "500ms") for a batch to fill.
+type BatchConfig struct {
+	Size   int           `mapstructure:"size"`
+	Linger time.Duration `mapstructure:"linger"`
+}
+
+// BufferConfig enables a disk-backed buffer for an output when Path is set.
+// Sizes are in bytes.
+type BufferConfig struct {
+	Path        string `mapstructure:"path"`
+	MaxSize     int64  `mapstructure:"max_size"`
+	SegmentSize int64  `mapstructure:"segment_size"`
+}
+
+// RoutingConfig sends each event to the outputs of every route whose
+// condition matches, or to Default when none match. Without routes every
+// event goes to every output.
+type RoutingConfig struct {
+	Routes  []RouteConfig `mapstructure:"routes"`
+	Default []string      `mapstructure:"default"`
+}
+
+type RouteConfig struct {
+	Name    string          `mapstructure:"name"`
+	When    ConditionConfig `mapstructure:"when"`
+	Outputs []string        `mapstructure:"outputs"`
+}
+
+// ConditionConfig tests a single field, addressed with a dot path. All
+// criteria that are set must hold; Equals may be set to "" to match an empty
+// value.
+type ConditionConfig struct {
+	Field   string   `mapstructure:"field"`
+	Equals  *string  `mapstructure:"equals"`
+	In      []string `mapstructure:"in"`
+	Matches string   `mapstructure:"matches"`
+	Exists  *bool    `mapstructure:"exists"`
 }
 
 type FilterConfig struct {
+	Name         string            `mapstructure:"name"`
 	Type         string            `mapstructure:"type"`
 	DropFields   []string          `mapstructure:"drop_fields"`
 	RenameFields map[string]string `mapstructure:"rename_fields"`
 	AddFields    map[string]string `mapstructure:"add_fields"`
+
+	// Settings holds the keys of other filter types.
+	Settings map[string]interface{} `mapstructure:",remain"`
+}
+
+// Options returns the type-specific settings passed to filters.NewFilter.
+func (f FilterConfig) Options() map[string]interface{} {
+	opts := make(map[string]interface{}, len(f.Settings)+3)
+	for k, v := range f.Settings {
+		opts[k] = v
+	}
+	if f.DropFields != nil {
+		opts["drop_fields"] = f.DropFields
+	}
+	if f.RenameFields != nil {
+		opts["rename_fields"] = f.RenameFields
+	}
+	if f.AddFields != nil {
+		opts["add_fields"] = f.AddFields
+	}
+	return opts
+}
+
+// AllInputs returns the configured inputs, accepting both the single `input:`
+// block and the `inputs:` list. Inputs without an id are named after their
+// type, with a suffix when the type appears more than once.
+func (c PipelineConfig) AllInputs() []InputConfig {
+	var inputs []InputConfig
+	if c.Input.Type != "" {
+		inputs = append(inputs, c.Input)
+	}
+	inputs = append(inputs, c.Inputs...)
+
+	seen := make(map[string]int)
+	for i := range inputs {
+		if inputs[i].ID == "" {
+			inputs[i].ID = inputs[i].Type
+		}
+		seen[inputs[i].ID]++
+		if n := seen[inputs[i].ID]; n > 1 {
+			inputs[i].ID = fmt.Sprintf("%s-%d", inputs[i].ID, n)
+		}
+	}
+	return inputs
+}
+
+// AllFilters returns the configured filters in order. Filters without a name
+// are named after their type, with a suffix when the type appears more than
+// once.
+func (c PipelineConfig) AllFilters() []FilterConfig {
+	filters := make([]FilterConfig, len(c.Filters))
+	copy(filters, c.Filters)
+
+	seen := make(map[string]int)
+	for i := range filters {
+		if filters[i].Name == "" {
+			filters[i].Name = filters[i].Type
+		}
+		seen[filters[i].Name]++
+		if n := seen[filters[i].Name]; n > 1 {
+			filters[i].Name = fmt.Sprintf("%s-%d", filters[i].Name, n)
+		}
+	}
+	return filters
 }
 
-func Load(path string) (*PipelineConfig, map[string]interface{}, error) {
+// AllOutputs returns the configured outputs, accepting both the single
+// `output:` block and the `outputs:` list. Outputs without a name are named
+// after their type, with a suffix when the type appears more than once.
+func (c PipelineConfig) AllOutputs() []OutputConfig {
+	var outputs []OutputConfig
+	if c.Output.Type != "" {
+		outputs = append(outputs, c.Output)
+	}
+	outputs = append(outputs, c.Outputs...)
+
+	seen := make(map[string]int)
+	for i := range outputs {
+		if outputs[i].Name == "" {
+			outputs[i].Name = outputs[i].Type
+		}
+		seen[outputs[i].Name]++
+		if n := seen[outputs[i].Name]; n > 1 {
+			outputs[i].Name = fmt.Sprintf("%s-%d", outputs[i].Name, n)
+		}
+	}
+	return outputs
+}
+
+func Load(path string) (*Config, map[string]interface{}, error) {
 	v := viper.New()
 	v.SetConfigFile(path)
 	if err := v.ReadInConfig(); err != nil {
 		return nil, nil, fmt.Errorf("failed to read config: %w", err)
 	}
 
-	var config PipelineConfig
+	var config Config
 	if err := v.Unmarshal(&config); err != nil {
 		return nil, nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
+	if _, clash := config.Pipelines[DefaultPipelineName]; clash && len(config.AllInputs()) > 0 {
+		return nil, nil, fmt.Errorf("pipeline %q is defined both at the top level and under 'pipelines'", DefaultPipelineName)
+	}
+
 	fmt.Printf("Loaded config: %s\n", path)
 	var raw map[string]interface{}
 	if err := v.Unmarshal(&raw); err != nil {
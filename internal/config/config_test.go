@@ -28,3 +28,95 @@ func TestLoadValidConfig(t *testing.T) {
 		t.Error("input.type should not be empty")
 	}
 }
+
+func TestLoadMultipleOutputs(t *testing.T) {
+	cfg, _, err := config.Load("testdata/multi_output_config.yaml")
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	outputs := cfg.AllOutputs()
+	if len(outputs) != 3 {
+		t.Fatalf("expected 3 outputs, got %d", len(outputs))
+	}
+
+	names := []string{outputs[0].Name, outputs[1].Name, outputs[2].Name}
+	expected := []string{"loki", "archive", "file"}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("output %d: expected name %q, got %q", i, expected[i], names[i])
+		}
+	}
+
+	labels, ok := outputs[0].Options()["labels"].(map[string]interface{})
+	if !ok || labels["job"] != "flox" {
+		t.Errorf("expected loki labels to be passed through, got %v", outputs[0].Options())
+	}
+	if outputs[1].Queue.WhenFull != "drop_oldest" {
+		t.Errorf("expected archive queue policy drop_oldest, got %q", outputs[1].Queue.WhenFull)
+	}
+}
+
+func TestAllOutputsIncludesSingleOutput(t *testing.T) {
+	cfg := config.PipelineConfig{
+		Output:  config.OutputConfig{Type: "stdout"},
+		Outputs: []config.OutputConfig{{Type: "stdout"}},
+	}
+
+	outputs := cfg.AllOutputs()
+	if len(outputs) != 2 || outputs[0].Name != "stdout" || outputs[1].Name != "stdout-2" {
+		t.Errorf("expected stdout and stdout-2, got %+v", outputs)
+	}
+}
+
+func TestLoadMultiplePipelines(t *testing.T) {
+	cfg, _, err := config.Load("testdata/multi_pipeline_config.yaml")
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	pipelines := cfg.AllPipelines()
+	if len(pipelines) != 2 {
+		t.Fatalf("expected 2 pipelines, got %d", len(pipelines))
+	}
+	if pipelines["pods"].Input.Type != "file" {
+		t.Errorf("expected pods pipeline to use file input, got %q", pipelines["pods"].Input.Type)
+	}
+	if outputs := pipelines["audit"].AllOutputs(); len(outputs) != 1 || outputs[0].Type != "kafka" {
+		t.Errorf("expected audit pipeline to have a single kafka output, got %+v", outputs)
+	}
+}
+
+func TestLoadSinglePipelineIsDefault(t *testing.T) {
+	cfg, _, err := config.Load("testdata/valid_config.yaml")
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	pipelines := cfg.AllPipelines()
+	if _, ok := pipelines[config.DefaultPipelineName]; !ok || len(pipelines) != 1 {
+		t.Errorf("expected a single %q pipeline, got %v", config.DefaultPipelineName, pipelines)
+	}
+}
+
+func TestAllInputs(t *testing.T) {
+	cfg := config.PipelineConfig{
+		Inputs: []config.InputConfig{
+			{Type: "file", Path: "/var/log/pods/*/*/*.log"},
+			{Type: "file", Path: "/var/log/app/*.log"},
+			{ID: "audit", Type: "http", Address: ":8080"},
+		},
+	}
+
+	inputs := cfg.AllInputs()
+	if len(inputs) != 3 {
+		t.Fatalf("expected 3 inputs, got %d", len(inputs))
+	}
+	ids := []string{inputs[0].ID, inputs[1].ID, inputs[2].ID}
+	expected := []string{"file", "file-2", "audit"}
+	for i := range expected {
+		if ids[i] != expected[i] {
+			t.Errorf("input %d: expected id %q, got %q", i, expected[i], ids[i])
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const watchDebounce = 500 * time.Millisecond
+
+// Watch calls onChange whenever the contents of the config file at path
+// change, until ctx is cancelled. The parent directory is watched rather
+// than the file itself so editors that replace the file and Kubernetes
+// ConfigMap volumes, which swap a symlink, are both picked up.
+func Watch(ctx context.Context, path string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+
+	last, _ := os.ReadFile(path)
+
+	go func() {
+		defer func() {
+			_ = watcher.Close()
+		}()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !affects(ev.Name, path) {
+					continue
+				}
+				debounce = time.After(watchDebounce)
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("[Config] Watch error: %v", err)
+
+			case <-debounce:
+				debounce = nil
+				current, err := os.ReadFile(path)
+				if err != nil || bytes.Equal(current, last) {
+					continue
+				}
+				last = current
+				onChange()
+			}
+		}
+	}()
+	return nil
+}
+
+// affects reports whether an event for name in the config file's directory
+// may have changed the file at path: either the file itself or, in a
+// ConfigMap volume, the ..data symlink that is swapped on every update.
+// Other files there, such as the state file the file input rewrites on
+// every checkpoint, would otherwise keep postponing the reload.
+func affects(name, path string) bool {
+	name = filepath.Clean(name)
+	return name == filepath.Clean(path) || filepath.Base(name) == "..data"
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchCallsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("output:\n  type: stdout\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan struct{}, 1)
+	if err := Watch(ctx, path, func() { changed <- struct{}{} }); err != nil {
+		t.Fatalf("failed to watch config: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("output:\n  type: file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected onChange after the config file was modified")
+	}
+}
+
+func TestWatchIgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("output:\n  type: stdout\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan struct{}, 1)
+	if err := Watch(ctx, path, func() { changed <- struct{}{} }); err != nil {
+		t.Fatalf("failed to watch config: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("output:\n  type: file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file rewritten more often than the debounce, like the state file,
+	// must not hold the reload back.
+	ticker := time.NewTicker(watchDebounce / 5)
+	defer ticker.Stop()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case <-changed:
+			return
+		case <-ticker.C:
+			if err := os.WriteFile(filepath.Join(dir, ".flox.state"), []byte(time.Now().String()), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		case <-deadline:
+			t.Fatal("expected onChange while another file in the directory kept changing")
+		}
+	}
+}
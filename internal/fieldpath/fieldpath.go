@@ -0,0 +1,247 @@
+// Package fieldpath addresses fields inside nested events.
+//
+// A path is a list of keys separated by dots, such as
+// "kubernetes.pod_name". A key containing a dot is written with the dot
+// escaped, as in "labels.app\.kubernetes\.io/name", and a literal backslash
+// as "\\". The key "*" matches every key of an object and every element of
+// an array; write "\*" for a key that is literally "*". Array elements can
+// also be addressed by their index, as in "items.0.id".
+package fieldpath
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type segment struct {
+	key      string
+	wildcard bool
+}
+
+func parse(path string) []segment {
+	var (
+		segs    []segment
+		key     strings.Builder
+		escaped bool
+		literal bool
+	)
+	flush := func() {
+		k := key.String()
+		segs = append(segs, segment{key: k, wildcard: k == "*" && !literal})
+		key.Reset()
+		literal = false
+	}
+
+	for _, r := range path {
+		switch {
+		case escaped:
+			key.WriteRune(r)
+			literal = true
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '.':
+			flush()
+		default:
+			key.WriteRune(r)
+		}
+	}
+	if escaped {
+		key.WriteRune('\\')
+	}
+	flush()
+	return segs
+}
+
+// match is a field found by walking a path. The field lives in parent under
+// key, which is an index when parent is an array. captures holds the keys
+// that the path's wildcards matched, in order.
+type match struct {
+	parent   interface{}
+	key      string
+	captures []string
+}
+
+func (m match) value() interface{} {
+	switch p := m.parent.(type) {
+	case map[string]interface{}:
+		return p[m.key]
+	case []interface{}:
+		i, _ := strconv.Atoi(m.key)
+		return p[i]
+	}
+	return nil
+}
+
+// children lists the keys of node that seg selects, in a stable order.
+func children(node interface{}, seg segment) []string {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if !seg.wildcard {
+			if _, ok := n[seg.key]; ok {
+				return []string{seg.key}
+			}
+			return nil
+		}
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys
+
+	case []interface{}:
+		if !seg.wildcard {
+			if i, err := strconv.Atoi(seg.key); err == nil && i >= 0 && i < len(n) {
+				return []string{seg.key}
+			}
+			return nil
+		}
+		keys := make([]string, len(n))
+		for i := range n {
+			keys[i] = strconv.Itoa(i)
+		}
+		return keys
+	}
+	return nil
+}
+
+func walk(node interface{}, segs []segment, captures []string, fn func(match)) {
+	seg := segs[0]
+	for _, key := range children(node, seg) {
+		caps := captures
+		if seg.wildcard {
+			caps = append(captures[:len(captures):len(captures)], key)
+		}
+		m := match{parent: node, key: key, captures: caps}
+		if len(segs) == 1 {
+			fn(m)
+			continue
+		}
+		walk(m.value(), segs[1:], caps, fn)
+	}
+}
+
+func find(event map[string]interface{}, path string) []match {
+	var matches []match
+	walk(event, parse(path), nil, func(m match) {
+		matches = append(matches, m)
+	})
+	return matches
+}
+
+// Get returns the value at path. If the path contains wildcards, the first
+// match is returned.
+func Get(event map[string]interface{}, path string) (interface{}, bool) {
+	matches := find(event, path)
+	if len(matches) == 0 {
+		return nil, false
+	}
+	return matches[0].value(), true
+}
+
+// GetAll returns the values of every field matched by path.
+func GetAll(event map[string]interface{}, path string) []interface{} {
+	matches := find(event, path)
+	values := make([]interface{}, len(matches))
+	for i, m := range matches {
+		values[i] = m.value()
+	}
+	return values
+}
+
+// Delete removes every object field matched by path and reports how many
+// were removed. Array elements are left in place.
+func Delete(event map[string]interface{}, path string) int {
+	n := 0
+	for _, m := range find(event, path) {
+		if obj, ok := m.parent.(map[string]interface{}); ok {
+			delete(obj, m.key)
+			n++
+		}
+	}
+	return n
+}
+
+// Set stores value at path. Missing objects along the way are created, and
+// an existing non-object value in the way is replaced by an object. A
+// wildcard sets the field under every existing key or element it matches.
+func Set(event map[string]interface{}, path string, value interface{}) {
+	set(event, parse(path), value)
+}
+
+func set(node interface{}, segs []segment, value interface{}) {
+	seg := segs[0]
+	if seg.wildcard {
+		for _, key := range children(node, seg) {
+			setChild(node, key, segs[1:], value)
+		}
+		return
+	}
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(segs) > 1 {
+			if _, ok := n[seg.key].(map[string]interface{}); !ok {
+				if _, ok := n[seg.key].([]interface{}); !ok {
+					n[seg.key] = make(map[string]interface{})
+				}
+			}
+		}
+		setChild(n, seg.key, segs[1:], value)
+	case []interface{}:
+		if len(children(n, seg)) == 1 {
+			setChild(n, seg.key, segs[1:], value)
+		}
+	}
+}
+
+func setChild(node interface{}, key string, rest []segment, value interface{}) {
+	m := match{parent: node, key: key}
+	if len(rest) > 0 {
+		set(m.value(), rest, value)
+		return
+	}
+	switch p := node.(type) {
+	case map[string]interface{}:
+		p[key] = value
+	case []interface{}:
+		i, _ := strconv.Atoi(key)
+		p[i] = value
+	}
+}
+
+// Rename moves every object field matched by from to to. Wildcards in to are
+// filled in with the keys the wildcards in from matched, so
+// "items.*.msg" → "items.*.message" renames the field within each item. It
+// reports how many fields were moved.
+func Rename(event map[string]interface{}, from, to string) int {
+	matches := find(event, from)
+	target := parse(to)
+	n := 0
+	for _, m := range matches {
+		obj, ok := m.parent.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value := obj[m.key]
+		delete(obj, m.key)
+		set(event, fill(target, m.captures), value)
+		n++
+	}
+	return n
+}
+
+// fill replaces the wildcards in segs with captures, in order. Wildcards
+// beyond the captured keys are kept.
+func fill(segs []segment, captures []string) []segment {
+	out := make([]segment, len(segs))
+	copy(out, segs)
+	for i := range out {
+		if out[i].wildcard && len(captures) > 0 {
+			out[i] = segment{key: captures[0]}
+			captures = captures[1:]
+		}
+	}
+	return out
+}
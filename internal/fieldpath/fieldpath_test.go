@@ -0,0 +1,133 @@
+package fieldpath_test
+
+import (
+	"testing"
+
+	"github.com/kpiljoong/flox/internal/fieldpath"
+)
+
+func TestGet(t *testing.T) {
+	event := map[string]interface{}{
+		"level": "info",
+		"kubernetes": map[string]interface{}{
+			"namespace": "payments",
+		},
+	}
+
+	if v, ok := fieldpath.Get(event, "level"); !ok || v != "info" {
+		t.Errorf("expected top-level lookup to return info, got %v", v)
+	}
+	if v, ok := fieldpath.Get(event, "kubernetes.namespace"); !ok || v != "payments" {
+		t.Errorf("expected nested lookup to return payments, got %v", v)
+	}
+	if _, ok := fieldpath.Get(event, "kubernetes.pod_name"); ok {
+		t.Error("expected missing nested key to report not found")
+	}
+	if _, ok := fieldpath.Get(event, "level.sub"); ok {
+		t.Error("expected lookup through a non-object to report not found")
+	}
+}
+
+func TestGetEscapedAndIndexed(t *testing.T) {
+	event := map[string]interface{}{
+		"labels": map[string]interface{}{"app.kubernetes.io/name": "api"},
+		"items":  []interface{}{map[string]interface{}{"id": "a"}, map[string]interface{}{"id": "b"}},
+		"*":      "star",
+	}
+
+	if v, ok := fieldpath.Get(event, `labels.app\.kubernetes\.io/name`); !ok || v != "api" {
+		t.Errorf("expected escaped dots to address a single key, got %v", v)
+	}
+	if v, ok := fieldpath.Get(event, "items.1.id"); !ok || v != "b" {
+		t.Errorf("expected array index lookup to return b, got %v", v)
+	}
+	if v, ok := fieldpath.Get(event, `\*`); !ok || v != "star" {
+		t.Errorf("expected escaped star to address a literal key, got %v", v)
+	}
+	if got := fieldpath.GetAll(event, "items.*.id"); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("expected wildcard to match every item, got %v", got)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	event := map[string]interface{}{
+		"request": map[string]interface{}{
+			"headers": map[string]interface{}{"authorization": "secret", "accept": "*/*"},
+		},
+		"users": []interface{}{
+			map[string]interface{}{"name": "a", "password": "1"},
+			map[string]interface{}{"name": "b", "password": "2"},
+		},
+	}
+
+	if n := fieldpath.Delete(event, "request.headers.authorization"); n != 1 {
+		t.Errorf("expected 1 field deleted, got %d", n)
+	}
+	if _, ok := fieldpath.Get(event, "request.headers.authorization"); ok {
+		t.Error("expected nested field to be deleted")
+	}
+	if _, ok := fieldpath.Get(event, "request.headers.accept"); !ok {
+		t.Error("expected sibling field to remain")
+	}
+
+	if n := fieldpath.Delete(event, "users.*.password"); n != 2 {
+		t.Errorf("expected 2 fields deleted through wildcard, got %d", n)
+	}
+	if n := fieldpath.Delete(event, "missing.field"); n != 0 {
+		t.Errorf("expected nothing deleted for missing path, got %d", n)
+	}
+}
+
+func TestSet(t *testing.T) {
+	event := map[string]interface{}{
+		"level": "info",
+		"items": []interface{}{map[string]interface{}{}, map[string]interface{}{}},
+	}
+
+	fieldpath.Set(event, "kubernetes.labels.team", "payments")
+	if v, _ := fieldpath.Get(event, "kubernetes.labels.team"); v != "payments" {
+		t.Errorf("expected intermediate objects to be created, got %v", event["kubernetes"])
+	}
+
+	fieldpath.Set(event, "level.severity", 3)
+	if v, _ := fieldpath.Get(event, "level.severity"); v != 3 {
+		t.Errorf("expected scalar in the way to be replaced by an object, got %v", event["level"])
+	}
+
+	fieldpath.Set(event, "items.*.seen", true)
+	if got := fieldpath.GetAll(event, "items.*.seen"); len(got) != 2 {
+		t.Errorf("expected field set on every item, got %v", got)
+	}
+
+	fieldpath.Set(event, `a\.b`, 1)
+	if event["a.b"] != 1 {
+		t.Errorf("expected escaped dot to set a top-level key, got %v", event)
+	}
+}
+
+func TestRename(t *testing.T) {
+	event := map[string]interface{}{
+		"kubernetes": map[string]interface{}{"pod_name": "api-1"},
+		"items": []interface{}{
+			map[string]interface{}{"msg": "a"},
+			map[string]interface{}{"msg": "b"},
+		},
+	}
+
+	if n := fieldpath.Rename(event, "kubernetes.pod_name", "pod.name"); n != 1 {
+		t.Errorf("expected 1 field renamed, got %d", n)
+	}
+	if v, _ := fieldpath.Get(event, "pod.name"); v != "api-1" {
+		t.Errorf("expected field moved to pod.name, got %v", event)
+	}
+	if _, ok := fieldpath.Get(event, "kubernetes.pod_name"); ok {
+		t.Error("expected old field to be removed")
+	}
+
+	if n := fieldpath.Rename(event, "items.*.msg", "items.*.message"); n != 2 {
+		t.Errorf("expected 2 fields renamed, got %d", n)
+	}
+	if got := fieldpath.GetAll(event, "items.*.message"); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("expected each item renamed in place, got %v", event["items"])
+	}
+}
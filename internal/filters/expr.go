@@ -0,0 +1,173 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+
+	"github.com/kpiljoong/flox/internal/fieldpath"
+	"github.com/kpiljoong/flox/internal/plugin"
+)
+
+func init() {
+	// Decode compiles the expressions, so `flox validate` reports syntax
+	// errors and the filter that is built reuses the compiled programs.
+	Register("expr", Factory{
+		Description: "Drops events by condition and computes fields with expressions",
+		Decode: func(settings map[string]interface{}) (interface{}, error) {
+			var cfg ExprFilterConfig
+			if err := plugin.Decode(settings, &cfg); err != nil {
+				return nil, err
+			}
+			return NewExprFilter(cfg)
+		},
+		New: func(_ context.Context, cfg interface{}) (Filter, error) {
+			f, ok := cfg.(*ExprFilter)
+			if !ok {
+				return nil, fmt.Errorf("unexpected config type %T", cfg)
+			}
+			return f, nil
+		},
+	})
+}
+
+// ExprFilterConfig configures the expr filter. Expressions use the
+// expr-lang syntax (https://expr-lang.org) with the event's fields as
+// variables, so nested objects are reached with dots, e.g.
+// `kubernetes.namespace == "dev"`. Fields that are missing evaluate to nil.
+//
+// An event is dropped if DropIf is true or KeepIf is false. Events that are
+// kept then get one field per Set entry, written as "field = expression" and
+// evaluated in order, so later entries see the fields set by earlier ones.
+// The field is a fieldpath path and may be nested.
+type ExprFilterConfig struct {
+	DropIf string   `mapstructure:"drop_if"`
+	KeepIf string   `mapstructure:"keep_if"`
+	Set    []string `mapstructure:"set"`
+}
+
+// ExprFilter evaluates precompiled expressions against each event.
+type ExprFilter struct {
+	dropIf *vm.Program
+	keepIf *vm.Program
+	set    []exprAssignment
+}
+
+type exprAssignment struct {
+	field   string
+	program *vm.Program
+}
+
+var assignmentPattern = regexp.MustCompile(`^\s*([^\s=]+)\s*=([^=].*)$`)
+
+// NewExprFilter compiles every expression in cfg and reports the first
+// that fails.
+func NewExprFilter(cfg ExprFilterConfig) (*ExprFilter, error) {
+	if cfg.DropIf == "" && cfg.KeepIf == "" && len(cfg.Set) == 0 {
+		return nil, errors.New("expr filter needs drop_if, keep_if or set")
+	}
+
+	f := &ExprFilter{}
+	var err error
+	if cfg.DropIf != "" {
+		if f.dropIf, err = compileExpr(cfg.DropIf, expr.AsBool()); err != nil {
+			return nil, fmt.Errorf("drop_if: %w", err)
+		}
+	}
+	if cfg.KeepIf != "" {
+		if f.keepIf, err = compileExpr(cfg.KeepIf, expr.AsBool()); err != nil {
+			return nil, fmt.Errorf("keep_if: %w", err)
+		}
+	}
+	for i, assignment := range cfg.Set {
+		m := assignmentPattern.FindStringSubmatch(assignment)
+		if m == nil {
+			return nil, fmt.Errorf("set[%d]: expected \"field = expression\", got %q", i, assignment)
+		}
+		program, err := compileExpr(m[2])
+		if err != nil {
+			return nil, fmt.Errorf("set[%d]: %w", i, err)
+		}
+		f.set = append(f.set, exprAssignment{field: m[1], program: program})
+	}
+	return f, nil
+}
+
+func compileExpr(source string, opts ...expr.Option) (*vm.Program, error) {
+	opts = append([]expr.Option{
+		expr.Env(map[string]interface{}{}),
+		expr.AllowUndefinedVariables(),
+	}, opts...)
+	return expr.Compile(source, opts...)
+}
+
+// Process drops the event if a condition says so and otherwise sets the
+// computed fields. If any expression fails the event is forwarded without
+// the computed fields.
+func (f *ExprFilter) Process(event map[string]interface{}) Result {
+	if f.dropIf != nil {
+		drop, err := test(f.dropIf, event)
+		if err != nil {
+			return Fail(event, fmt.Errorf("drop_if: %w", err))
+		}
+		if drop {
+			return Drop()
+		}
+	}
+	if f.keepIf != nil {
+		keep, err := test(f.keepIf, event)
+		if err != nil {
+			return Fail(event, fmt.Errorf("keep_if: %w", err))
+		}
+		if !keep {
+			return Drop()
+		}
+	}
+
+	// Compute every field on a copy before writing any, as later
+	// expressions read earlier results and a failure must leave the event
+	// untouched.
+	if len(f.set) == 0 {
+		return Pass(event)
+	}
+	env := copyObject(event)
+	values := make([]interface{}, len(f.set))
+	for i, a := range f.set {
+		value, err := expr.Run(a.program, env)
+		if err != nil {
+			return Fail(event, fmt.Errorf("set %s: %w", a.field, err))
+		}
+		fieldpath.Set(env, a.field, value)
+		values[i] = value
+	}
+	for i, a := range f.set {
+		fieldpath.Set(event, a.field, values[i])
+	}
+	return Pass(event)
+}
+
+// copyObject copies nested objects so they can be written without touching
+// the original. Other values, including arrays, are shared.
+func copyObject(obj map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		if nested, ok := v.(map[string]interface{}); ok {
+			v = copyObject(nested)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func test(program *vm.Program, event map[string]interface{}) (bool, error) {
+	result, err := expr.Run(program, event)
+	if err != nil {
+		return false, err
+	}
+	ok, _ := result.(bool)
+	return ok, nil
+}
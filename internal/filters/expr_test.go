@@ -0,0 +1,112 @@
+package filters_test
+
+import (
+	"testing"
+
+	"github.com/kpiljoong/flox/internal/filters"
+)
+
+func TestExprFilter_DropIf(t *testing.T) {
+	f, err := filters.NewExprFilter(filters.ExprFilterConfig{
+		DropIf: `level in ["debug", "trace"] && kubernetes.namespace == "dev"`,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dev := map[string]interface{}{"level": "debug", "kubernetes": map[string]interface{}{"namespace": "dev"}}
+	if f.Process(dev).Outcome != filters.OutcomeDrop {
+		t.Error("expected debug event from dev to be dropped")
+	}
+
+	prod := map[string]interface{}{"level": "debug", "kubernetes": map[string]interface{}{"namespace": "prod"}}
+	if f.Process(prod).Outcome != filters.OutcomePass {
+		t.Error("expected debug event from prod to be kept")
+	}
+
+	if f.Process(map[string]interface{}{"msg": "no level"}).Outcome != filters.OutcomePass {
+		t.Error("expected event with missing fields to be kept")
+	}
+}
+
+func TestExprFilter_KeepIf(t *testing.T) {
+	f, err := filters.NewExprFilter(filters.ExprFilterConfig{KeepIf: `status >= 500`})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if f.Process(map[string]interface{}{"status": 503}).Outcome != filters.OutcomePass {
+		t.Error("expected matching event to be kept")
+	}
+	if f.Process(map[string]interface{}{"status": 200}).Outcome != filters.OutcomeDrop {
+		t.Error("expected non-matching event to be dropped")
+	}
+}
+
+func TestExprFilter_Set(t *testing.T) {
+	f, err := filters.NewExprFilter(filters.ExprFilterConfig{
+		Set: []string{
+			"duration_ms = end - start",
+			"slow = duration_ms > 100",
+			"timing.seconds = duration_ms / 1000",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	event := f.Process(map[string]interface{}{"start": 1000, "end": 1250}).Events[0]
+	if event["duration_ms"] != 250 {
+		t.Errorf("expected duration_ms 250, got %v", event["duration_ms"])
+	}
+	if event["slow"] != true {
+		t.Errorf("expected slow to be computed from duration_ms, got %v", event["slow"])
+	}
+	if timing, _ := event["timing"].(map[string]interface{}); timing["seconds"] != 0.25 {
+		t.Errorf("expected nested timing.seconds to be set, got %v", event["timing"])
+	}
+}
+
+func TestExprFilter_RuntimeErrorKeepsEvent(t *testing.T) {
+	f, err := filters.NewExprFilter(filters.ExprFilterConfig{
+		Set: []string{"ok = true", "duration_ms = end - start"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res := f.Process(map[string]interface{}{"start": "soon"})
+	if res.Outcome != filters.OutcomeError || res.Err == nil {
+		t.Fatalf("expected error outcome, got %+v", res)
+	}
+	if len(res.Events) != 1 {
+		t.Fatalf("expected the event to be passed on, got %v", res.Events)
+	}
+	if _, set := res.Events[0]["ok"]; set {
+		t.Error("expected no computed fields on a failed event")
+	}
+}
+
+func TestExprFilter_CompileErrors(t *testing.T) {
+	bad := []filters.ExprFilterConfig{
+		{},
+		{DropIf: `level ==`},
+		{KeepIf: `"not a bool"`},
+		{Set: []string{"missing_equals"}},
+		{Set: []string{"x = (1 +"}},
+	}
+	for _, cfg := range bad {
+		if _, err := filters.NewExprFilter(cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestExprFilter_Validate(t *testing.T) {
+	if err := filters.Validate("expr", map[string]interface{}{"drop_if": `level == "debug"`}); err != nil {
+		t.Errorf("expected valid config, got %v", err)
+	}
+	if err := filters.Validate("expr", map[string]interface{}{"drop_if": `level ==`}); err == nil {
+		t.Error("expected validation to report the bad expression")
+	}
+}
@@ -0,0 +1,100 @@
+package filters
+
+import (
+	"context"
+
+	"github.com/kpiljoong/flox/internal/plugin"
+)
+
+// Filter processes one event at a time and reports what it did in a Result.
+// Filters may modify the event in place.
+type Filter interface {
+	Process(event map[string]interface{}) Result
+}
+
+// Outcome is what a filter did with an event.
+type Outcome int
+
+const (
+	// OutcomePass forwards a single, possibly modified, event.
+	OutcomePass Outcome = iota
+	// OutcomeDrop discards the event.
+	OutcomeDrop
+	// OutcomeError reports a failure. The event is forwarded as it was
+	// before the filter ran, so a broken filter never loses logs.
+	OutcomeError
+	// OutcomeSplit replaces the event with several.
+	OutcomeSplit
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomePass:
+		return "pass"
+	case OutcomeDrop:
+		return "drop"
+	case OutcomeError:
+		return "error"
+	case OutcomeSplit:
+		return "split"
+	default:
+		return "unknown"
+	}
+}
+
+// Result is returned by Filter.Process. Events holds the events to forward:
+// one for pass and error, none for drop and any number for split.
+type Result struct {
+	Outcome Outcome
+	Events  []map[string]interface{}
+	Err     error
+}
+
+// Pass forwards event.
+func Pass(event map[string]interface{}) Result {
+	return Result{Outcome: OutcomePass, Events: []map[string]interface{}{event}}
+}
+
+// Drop discards the event.
+func Drop() Result {
+	return Result{Outcome: OutcomeDrop}
+}
+
+// Fail reports err and forwards event, which should be the input unchanged.
+func Fail(event map[string]interface{}, err error) Result {
+	return Result{Outcome: OutcomeError, Events: []map[string]interface{}{event}, Err: err}
+}
+
+// Split replaces the event with events. Zero events count as a drop and a
+// single event as a pass.
+func Split(events []map[string]interface{}) Result {
+	switch len(events) {
+	case 0:
+		return Drop()
+	case 1:
+		return Pass(events[0])
+	default:
+		return Result{Outcome: OutcomeSplit, Events: events}
+	}
+}
+
+// Factory builds filters of one type from their type-specific settings.
+type Factory = plugin.Factory[Filter]
+
+var registry = plugin.NewRegistry[Filter](plugin.KindFilter)
+
+// Register makes a filter type available to pipelines. Filter
+// implementations call it from init.
+func Register(filterType string, factory Factory) {
+	registry.Register(filterType, factory)
+}
+
+// Validate checks that filterType is registered and config is valid for it.
+func Validate(filterType string, config map[string]interface{}) error {
+	return registry.Validate(filterType, config)
+}
+
+// NewFilter creates the registered filter for type and config.
+func NewFilter(ctx context.Context, filterType string, config map[string]interface{}) (Filter, error) {
+	return registry.Build(ctx, filterType, config)
+}
@@ -1,10 +1,51 @@
 package filters
 
 import (
+	"context"
+	"log"
 	"os"
 	"regexp"
+
+	"github.com/kpiljoong/flox/internal/fieldpath"
+	"github.com/kpiljoong/flox/internal/plugin"
 )
 
+func init() {
+	Register("json", plugin.Typed("Drops, renames and adds fields by path, including nested and wildcard fields",
+		func(_ context.Context, cfg JSONFilterConfig) (Filter, error) {
+			return NewJSONFilter(cfg.DropFields, cfg.renames(), cfg.AddFields), nil
+		}))
+}
+
+// JSONFilterConfig configures the json filter. Fields are addressed with
+// fieldpath paths, so nested fields, escaped dots and wildcards work in all
+// three operations. Added values of the form ${VAR} are read from the
+// environment.
+//
+// Rename is the deprecated spelling of RenameFields, accepted with a warning;
+// where both name a field, RenameFields wins.
+type JSONFilterConfig struct {
+	DropFields   []string          `mapstructure:"drop_fields"`
+	RenameFields map[string]string `mapstructure:"rename_fields"`
+	AddFields    map[string]string `mapstructure:"add_fields"`
+	Rename       map[string]string `mapstructure:"rename"`
+}
+
+func (c JSONFilterConfig) renames() map[string]string {
+	if len(c.Rename) == 0 {
+		return c.RenameFields
+	}
+	log.Printf("[Filter] The json filter's rename setting is deprecated, use rename_fields instead")
+	merged := make(map[string]string, len(c.Rename)+len(c.RenameFields))
+	for from, to := range c.Rename {
+		merged[from] = to
+	}
+	for from, to := range c.RenameFields {
+		merged[from] = to
+	}
+	return merged
+}
+
 type JSONFilter struct {
 	DropFields   []string
 	RenameFields map[string]string
@@ -21,29 +62,25 @@ func NewJSONFilter(drop []string, rename, add map[string]string) *JSONFilter {
 	}
 }
 
-func (f *JSONFilter) Process(event map[string]interface{}) map[string]interface{} {
+func (f *JSONFilter) Process(event map[string]interface{}) Result {
 	// Drop
-	for _, key := range f.DropFields {
-		delete(event, key)
+	for _, path := range f.DropFields {
+		fieldpath.Delete(event, path)
 	}
 
 	// Rename
-	for oldKey, newKey := range f.RenameFields {
-		if value, exists := event[oldKey]; exists {
-			event[newKey] = value
-			delete(event, oldKey)
-		}
+	for oldPath, newPath := range f.RenameFields {
+		fieldpath.Rename(event, oldPath, newPath)
 	}
 
 	// Enrich
-	for key, value := range f.AddFields {
+	for path, value := range f.AddFields {
 		if matches := envVarPattern.FindStringSubmatch(value); matches != nil {
-			envVal := os.Getenv(matches[1])
-			event[key] = envVal
+			fieldpath.Set(event, path, os.Getenv(matches[1]))
 		} else {
-			event[key] = value
+			fieldpath.Set(event, path, value)
 		}
 	}
 
-	return event
+	return Pass(event)
 }
@@ -1,6 +1,7 @@
 package filters_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/kpiljoong/flox/internal/filters"
@@ -20,7 +21,11 @@ func TestJSONFilter_Process(t *testing.T) {
 		"password": "1234",
 	}
 
-	processed := filter.Process(event)
+	result := filter.Process(event)
+	if result.Outcome != filters.OutcomePass || len(result.Events) != 1 {
+		t.Fatalf("expected a single passed event, got %+v", result)
+	}
+	processed := result.Events[0]
 
 	if _, exists := processed["secret"]; exists {
 		t.Errorf("expected 'secret' to be dropped")
@@ -39,3 +44,49 @@ func TestJSONFilter_Process(t *testing.T) {
 		t.Error("expected 'user' to remain unchanged")
 	}
 }
+
+func TestJSONFilter_NestedPaths(t *testing.T) {
+	filter := filters.NewJSONFilter(
+		[]string{"request.headers.authorization", "users.*.password"},
+		map[string]string{"kubernetes.pod_name": "kubernetes.pod"},
+		map[string]string{"meta.processed_by": "flox"},
+	)
+
+	event := map[string]interface{}{
+		"request": map[string]interface{}{
+			"headers": map[string]interface{}{"authorization": "secret"},
+		},
+		"users":      []interface{}{map[string]interface{}{"password": "1"}},
+		"kubernetes": map[string]interface{}{"pod_name": "api-1"},
+	}
+
+	processed := filter.Process(event).Events[0]
+
+	headers := processed["request"].(map[string]interface{})["headers"].(map[string]interface{})
+	if _, exists := headers["authorization"]; exists {
+		t.Error("expected nested authorization header to be dropped")
+	}
+	if _, exists := processed["users"].([]interface{})[0].(map[string]interface{})["password"]; exists {
+		t.Error("expected password to be dropped from every user")
+	}
+	if processed["kubernetes"].(map[string]interface{})["pod"] != "api-1" {
+		t.Errorf("expected kubernetes.pod_name to be renamed, got %v", processed["kubernetes"])
+	}
+	if processed["meta"].(map[string]interface{})["processed_by"] != "flox" {
+		t.Errorf("expected meta object to be created, got %v", processed["meta"])
+	}
+}
+
+func TestJSONFilter_DeprecatedRename(t *testing.T) {
+	filter, err := filters.NewFilter(context.Background(), "json", map[string]interface{}{
+		"rename": map[string]interface{}{"msg": "message"},
+	})
+	if err != nil {
+		t.Fatalf("expected rename to be accepted, got %v", err)
+	}
+
+	res := filter.Process(map[string]interface{}{"msg": "hello"})
+	if len(res.Events) != 1 || res.Events[0]["message"] != "hello" {
+		t.Errorf("expected msg to be renamed, got %v", res.Events)
+	}
+}
@@ -0,0 +1,273 @@
+package filters
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/kpiljoong/flox/internal/plugin"
+)
+
+const (
+	DefaultWasmMemoryLimit = 64 << 20
+	DefaultWasmTimeout     = 100 * time.Millisecond
+
+	wasmPageSize = 64 << 10
+)
+
+func init() {
+	Register("wasm", plugin.Typed("Runs each event through a WebAssembly module",
+		func(ctx context.Context, cfg WasmFilterConfig) (Filter, error) {
+			return NewWasmFilter(ctx, cfg)
+		}))
+}
+
+// WasmFilterConfig configures the wasm filter. MemoryLimit is in bytes and
+// caps the linear memory of each module instance; Timeout bounds a single
+// call into the module.
+type WasmFilterConfig struct {
+	Module      string        `mapstructure:"module"`
+	MemoryLimit int64         `mapstructure:"memory_limit"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+}
+
+func (c WasmFilterConfig) Validate() error {
+	if c.Module == "" {
+		return errors.New("module not specified for wasm filter")
+	}
+	if _, err := os.Stat(c.Module); err != nil {
+		return fmt.Errorf("wasm module: %w", err)
+	}
+	if c.MemoryLimit < 0 {
+		return errors.New("memory_limit must not be negative")
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+// WasmFilter passes each event to a WebAssembly module. Modules run in
+// wazero, a pure Go runtime, with WASI available for logging to stderr.
+//
+// A module exports its linear memory as "memory" and two functions:
+//
+//	flox_alloc(size i32) -> ptr i32
+//	flox_filter(ptr i32, size i32) -> i64
+//
+// For every event the host calls flox_alloc for a buffer of size bytes,
+// writes the event there as a JSON object and calls flox_filter with the
+// same pointer and size. The result packs a pointer into the upper 32 bits
+// and a length into the lower 32 bits, addressing a JSON document in the
+// module's memory:
+//
+//   - an object replaces the event,
+//   - an array of objects replaces it with several events,
+//   - a zero result, null or an empty array drops it.
+//
+// Both buffers only need to stay valid until flox_filter returns and the
+// host has read the result. An exported _initialize function, as produced
+// for Go and TinyGo reactors, is called once per instance.
+//
+// A module that traps, runs past its timeout or returns malformed JSON does
+// not take the event down with it: the filter reports an error outcome and
+// the event passes through unchanged. The failed instance is discarded and a
+// fresh one is created for the next event.
+type WasmFilter struct {
+	ctx      context.Context
+	path     string
+	timeout  time.Duration
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	pool     chan *wasmInstance
+}
+
+type wasmInstance struct {
+	mod    api.Module
+	alloc  api.Function
+	filter api.Function
+}
+
+// NewWasmFilter compiles the module at cfg.Module. Instances are created on
+// demand, so concurrent inputs each get their own.
+func NewWasmFilter(ctx context.Context, cfg WasmFilterConfig) (*WasmFilter, error) {
+	memoryLimit := cfg.MemoryLimit
+	if memoryLimit == 0 {
+		memoryLimit = DefaultWasmMemoryLimit
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = DefaultWasmTimeout
+	}
+
+	code, err := os.ReadFile(cfg.Module)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wasm module: %w", err)
+	}
+
+	pages := uint32(memoryLimit / wasmPageSize)
+	if pages == 0 {
+		pages = 1
+	}
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(pages).
+		WithCloseOnContextDone(true))
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		_ = r.Close(ctx)
+		return nil, fmt.Errorf("failed to set up WASI: %w", err)
+	}
+	compiled, err := r.CompileModule(ctx, code)
+	if err != nil {
+		_ = r.Close(ctx)
+		return nil, fmt.Errorf("failed to compile wasm module %s: %w", cfg.Module, err)
+	}
+	for _, name := range []string{"flox_alloc", "flox_filter"} {
+		if _, ok := compiled.ExportedFunctions()[name]; !ok {
+			_ = r.Close(ctx)
+			return nil, fmt.Errorf("wasm module %s does not export %s", cfg.Module, name)
+		}
+	}
+
+	f := &WasmFilter{
+		ctx:      ctx,
+		path:     cfg.Module,
+		timeout:  timeout,
+		runtime:  r,
+		compiled: compiled,
+		pool:     make(chan *wasmInstance, runtime.GOMAXPROCS(0)),
+	}
+
+	// Instantiate once up front so a module that cannot start fails the
+	// pipeline build instead of every event.
+	inst, err := f.instantiate()
+	if err != nil {
+		_ = r.Close(ctx)
+		return nil, err
+	}
+	f.put(inst)
+	return f, nil
+}
+
+// Process returns the events the module produced for event.
+func (f *WasmFilter) Process(event map[string]interface{}) Result {
+	events, err := f.call(event)
+	if err != nil {
+		return Fail(event, fmt.Errorf("wasm %s: %w", f.path, err))
+	}
+	return Split(events)
+}
+
+// Close releases the runtime and every instance.
+func (f *WasmFilter) Close() error {
+	return f.runtime.Close(context.Background())
+}
+
+func (f *WasmFilter) call(event map[string]interface{}) ([]map[string]interface{}, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	inst, err := f.get()
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := f.invoke(inst, data)
+	if err != nil {
+		_ = inst.mod.Close(context.Background())
+		return nil, err
+	}
+	f.put(inst)
+	return events, nil
+}
+
+func (f *WasmFilter) invoke(inst *wasmInstance, data []byte) ([]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(f.ctx, f.timeout)
+	defer cancel()
+
+	res, err := inst.alloc.Call(ctx, uint64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("flox_alloc failed: %w", err)
+	}
+	ptr := uint32(res[0])
+	if !inst.mod.Memory().Write(ptr, data) {
+		return nil, fmt.Errorf("flox_alloc returned out of range buffer %d+%d", ptr, len(data))
+	}
+
+	res, err = inst.filter.Call(ctx, uint64(ptr), uint64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("flox_filter failed: %w", err)
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	if outLen == 0 {
+		return nil, nil
+	}
+	out, ok := inst.mod.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, fmt.Errorf("flox_filter returned out of range result %d+%d", outPtr, outLen)
+	}
+	return decodeWasmResult(out)
+}
+
+func decodeWasmResult(out []byte) ([]map[string]interface{}, error) {
+	out = bytes.TrimSpace(out)
+	if len(out) > 0 && out[0] == '[' {
+		var events []map[string]interface{}
+		if err := json.Unmarshal(out, &events); err != nil {
+			return nil, fmt.Errorf("invalid result: %w", err)
+		}
+		return events, nil
+	}
+
+	var event map[string]interface{}
+	if err := json.Unmarshal(out, &event); err != nil {
+		return nil, fmt.Errorf("invalid result: %w", err)
+	}
+	if event == nil {
+		return nil, nil
+	}
+	return []map[string]interface{}{event}, nil
+}
+
+func (f *WasmFilter) get() (*wasmInstance, error) {
+	select {
+	case inst := <-f.pool:
+		return inst, nil
+	default:
+		return f.instantiate()
+	}
+}
+
+func (f *WasmFilter) put(inst *wasmInstance) {
+	select {
+	case f.pool <- inst:
+	default:
+		_ = inst.mod.Close(context.Background())
+	}
+}
+
+func (f *WasmFilter) instantiate() (*wasmInstance, error) {
+	mod, err := f.runtime.InstantiateModule(f.ctx, f.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStderr(os.Stderr))
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate wasm module %s: %w", f.path, err)
+	}
+	return &wasmInstance{
+		mod:    mod,
+		alloc:  mod.ExportedFunction("flox_alloc"),
+		filter: mod.ExportedFunction("flox_filter"),
+	}, nil
+}
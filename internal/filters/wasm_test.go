@@ -0,0 +1,149 @@
+package filters_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/kpiljoong/flox/internal/filters"
+)
+
+// spinModule is a hand-assembled module whose flox_filter never returns.
+// memPages sets the minimum size of its memory.
+func spinModule(memPages byte) []byte {
+	return []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic, version
+		// types: (i32) -> i32, (i32, i32) -> i64
+		0x01, 0x0c, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e,
+		// functions
+		0x03, 0x03, 0x02, 0x00, 0x01,
+		// memory
+		0x05, 0x03, 0x01, 0x00, memPages,
+		// exports: memory, flox_alloc, flox_filter
+		0x07, 0x25, 0x03,
+		0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+		0x0a, 'f', 'l', 'o', 'x', '_', 'a', 'l', 'l', 'o', 'c', 0x00, 0x00,
+		0x0b, 'f', 'l', 'o', 'x', '_', 'f', 'i', 'l', 't', 'e', 'r', 0x00, 0x01,
+		// code: flox_alloc returns 0, flox_filter loops forever
+		0x0a, 0x0f, 0x02,
+		0x04, 0x00, 0x41, 0x00, 0x0b,
+		0x08, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x00, 0x0b,
+	}
+}
+
+func writeModule(t *testing.T, code []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "module.wasm")
+	if err := os.WriteFile(path, code, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// buildExample compiles examples/wasm/redact, which needs Go 1.24 or later.
+func buildExample(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping wasm example build in short mode")
+	}
+	out := filepath.Join(t.TempDir(), "redact.wasm")
+	cmd := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), "build", "-buildmode=c-shared", "-o", out, ".")
+	cmd.Dir = filepath.Join("..", "..", "examples", "wasm", "redact")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("cannot build example wasm module: %v\n%s", err, msg)
+	}
+	return out
+}
+
+func TestWasmFilter_Example(t *testing.T) {
+	module := buildExample(t)
+
+	f, err := filters.NewWasmFilter(context.Background(), filters.WasmFilterConfig{
+		Module:  module,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to load module: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	res := f.Process(map[string]interface{}{"msg": "login", "password": "hunter2"})
+	if res.Outcome != filters.OutcomePass || res.Events[0]["password"] != "***" {
+		t.Errorf("expected one event with masked password, got %+v", res)
+	}
+
+	if res := f.Process(map[string]interface{}{"level": "debug"}); res.Outcome != filters.OutcomeDrop {
+		t.Errorf("expected debug event to be dropped, got %+v", res)
+	}
+
+	res = f.Process(map[string]interface{}{"host": "a", "records": []interface{}{"x", "y"}})
+	if res.Outcome != filters.OutcomeSplit || len(res.Events) != 2 {
+		t.Fatalf("expected event to be split in two, got %+v", res)
+	}
+	events := res.Events
+	for i, want := range []string{"x", "y"} {
+		if events[i]["record"] != want || events[i]["host"] != "a" {
+			t.Errorf("event %d: expected record %q with host, got %v", i, want, events[i])
+		}
+	}
+}
+
+func TestWasmFilter_TimeoutReportsError(t *testing.T) {
+	f, err := filters.NewWasmFilter(context.Background(), filters.WasmFilterConfig{
+		Module:  writeModule(t, spinModule(1)),
+		Timeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to load module: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		res := f.Process(map[string]interface{}{"msg": "hello"})
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("call %d: expected timeout to stop the module, took %s", i, elapsed)
+		}
+		if res.Outcome != filters.OutcomeError || len(res.Events) != 1 || res.Events[0]["msg"] != "hello" {
+			t.Errorf("call %d: expected error outcome passing the original event, got %+v", i, res)
+		}
+	}
+}
+
+func TestWasmFilter_MemoryLimit(t *testing.T) {
+	f, err := filters.NewWasmFilter(context.Background(), filters.WasmFilterConfig{
+		Module:      writeModule(t, spinModule(1)),
+		MemoryLimit: 2 * 64 << 10,
+	})
+	if err != nil {
+		t.Fatalf("expected module within the limit to load: %v", err)
+	}
+	_ = f.Close()
+
+	_, err = filters.NewWasmFilter(context.Background(), filters.WasmFilterConfig{
+		Module:      writeModule(t, spinModule(4)),
+		MemoryLimit: 2 * 64 << 10,
+	})
+	if err == nil {
+		t.Error("expected module needing more memory than the limit to be rejected")
+	}
+}
+
+func TestWasmFilter_MissingExports(t *testing.T) {
+	empty := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	_, err := filters.NewWasmFilter(context.Background(), filters.WasmFilterConfig{
+		Module: writeModule(t, empty),
+	})
+	if err == nil {
+		t.Error("expected module without the flox ABI exports to be rejected")
+	}
+}
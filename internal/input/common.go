@@ -1,3 +1,70 @@
 package input
 
-type HandlerFunc func(event map[string]interface{})
+import (
+	"context"
+
+	"github.com/kpiljoong/flox/internal/ack"
+	"github.com/kpiljoong/flox/internal/plugin"
+)
+
+type HandlerFunc func(event map[string]interface{}, tok *ack.Token)
+
+// Input produces events until ctx is cancelled. Run blocks until the input
+// has stopped.
+//
+// Inputs should acquire what can fail, such as a listening socket, when they
+// are built, so a bad config is rejected before anything is replaced. Such
+// an input also implements io.Closer, which releases it if Run is never
+// called.
+type Input interface {
+	Run(ctx context.Context, handle HandlerFunc)
+}
+
+// Factory builds inputs of one type from their type-specific settings.
+type Factory = plugin.Factory[Input]
+
+var registry = plugin.NewRegistry[Input](plugin.KindInput)
+
+// Register makes an input type available to pipelines. Input
+// implementations call it from init.
+func Register(inputType string, factory Factory) {
+	registry.Register(inputType, factory)
+}
+
+// Validate checks that inputType is registered and config is valid for it.
+func Validate(inputType string, config map[string]interface{}) error {
+	return registry.Validate(inputType, config)
+}
+
+type pipelineKey struct{}
+
+// WithPipeline returns a context that tells the inputs built with it which
+// pipeline they feed, for their metric labels.
+func WithPipeline(ctx context.Context, pipeline string) context.Context {
+	return context.WithValue(ctx, pipelineKey{}, pipeline)
+}
+
+// PipelineName returns the pipeline set by WithPipeline, or "" if none was.
+func PipelineName(ctx context.Context) string {
+	name, _ := ctx.Value(pipelineKey{}).(string)
+	return name
+}
+
+type inputIDKey struct{}
+
+// WithInputID returns a context that tells the input built with it its id,
+// for inputs that keep state per input, such as file checkpoints.
+func WithInputID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, inputIDKey{}, id)
+}
+
+// InputID returns the id set by WithInputID, or "" if none was.
+func InputID(ctx context.Context) string {
+	id, _ := ctx.Value(inputIDKey{}).(string)
+	return id
+}
+
+// NewInput creates the registered input for type and config.
+func NewInput(ctx context.Context, inputType string, config map[string]interface{}) (Input, error) {
+	return registry.Build(ctx, inputType, config)
+}
@@ -0,0 +1,163 @@
+package file
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kpiljoong/flox/internal/ack"
+)
+
+const (
+	// maxPendingOffsets bounds the lines of a file awaiting acknowledgement;
+	// the tailer stops reading the file while it is reached.
+	maxPendingOffsets = 100000
+
+	// checkpointInterval is how often a file's offset is saved at most.
+	checkpointInterval = time.Second
+
+	// DefaultRetryDelay is how long the tailer waits after a failed delivery
+	// before it reads the file again from the committed offset.
+	DefaultRetryDelay = 5 * time.Second
+)
+
+type pendingOffset struct {
+	end   int64
+	acked bool
+}
+
+// offsetTracker commits a file's offset only up to the highest position for
+// which every earlier line has been acknowledged, so an undelivered event is
+// re-read after a restart. A failed delivery holds the offset at the failed
+// line, and the tailer reads the file again from there once retryDelay has
+// passed. Offsets are saved at most every interval.
+type offsetTracker struct {
+	mu         sync.Mutex
+	path       string
+	base       uint64
+	offset     int64
+	pending    []pendingOffset
+	limit      int
+	failedAt   time.Time
+	retryDelay time.Duration
+	closed     bool
+	save       func(path string, offset int64)
+
+	interval time.Duration
+	saved    time.Time
+	dirty    bool
+	timer    *time.Timer
+}
+
+// newOffsetTracker tracks the lines of path that follow offset.
+func newOffsetTracker(path string, offset int64, save func(path string, offset int64)) *offsetTracker {
+	return &offsetTracker{
+		path:       path,
+		offset:     offset,
+		limit:      maxPendingOffsets,
+		retryDelay: DefaultRetryDelay,
+		save:       save,
+		interval:   checkpointInterval,
+	}
+}
+
+// track registers a line ending at end and returns the token that resolves it.
+func (tr *offsetTracker) track(end int64) *ack.Token {
+	tr.mu.Lock()
+	seq := tr.base + uint64(len(tr.pending))
+	tr.pending = append(tr.pending, pendingOffset{end: end})
+	tr.mu.Unlock()
+
+	return ack.New(func(ok bool) {
+		tr.resolve(seq, ok)
+	})
+}
+
+func (tr *offsetTracker) resolve(seq uint64, ok bool) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if tr.closed || seq < tr.base {
+		return
+	}
+	if !ok {
+		if tr.failedAt.IsZero() {
+			log.Printf("[Tailing] Delivery failed for %s, holding offset at %d and retrying in %s", tr.path, tr.offset, tr.retryDelay)
+			tr.failedAt = time.Now()
+		}
+		return
+	}
+	tr.pending[seq-tr.base].acked = true
+
+	committed := false
+	for len(tr.pending) > 0 && tr.pending[0].acked {
+		tr.offset = tr.pending[0].end
+		tr.pending = tr.pending[1:]
+		tr.base++
+		committed = true
+	}
+	if committed {
+		tr.commit()
+	}
+}
+
+// commit saves the committed offset, or schedules its save if the last one
+// is too recent. The caller holds mu.
+func (tr *offsetTracker) commit() {
+	wait := tr.interval - time.Since(tr.saved)
+	if wait <= 0 {
+		tr.saveLocked()
+		return
+	}
+	tr.dirty = true
+	if tr.timer == nil {
+		tr.timer = time.AfterFunc(wait, tr.flush)
+	}
+}
+
+func (tr *offsetTracker) flush() {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.timer = nil
+	if tr.dirty && !tr.closed {
+		tr.saveLocked()
+	}
+}
+
+// saveLocked saves the committed offset. The caller holds mu.
+func (tr *offsetTracker) saveLocked() {
+	tr.save(tr.path, tr.offset)
+	tr.saved = time.Now()
+	tr.dirty = false
+}
+
+// blocked reports whether the tailer should stop reading the file: too many
+// lines await acknowledgement, or a delivery failed and is to be retried.
+func (tr *offsetTracker) blocked() bool {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return len(tr.pending) >= tr.limit || !tr.failedAt.IsZero()
+}
+
+// retry returns the committed offset once a failed delivery is due to be
+// retried from there.
+func (tr *offsetTracker) retry() (int64, bool) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if tr.failedAt.IsZero() || time.Since(tr.failedAt) < tr.retryDelay {
+		return 0, false
+	}
+	return tr.offset, true
+}
+
+// close stops committing offsets, e.g. once the file has been truncated or
+// is read again after a failure.
+func (tr *offsetTracker) close() {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.closed = true
+	if tr.timer != nil {
+		tr.timer.Stop()
+		tr.timer = nil
+	}
+}
@@ -0,0 +1,110 @@
+package file
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOffsetTracker_CommitsContiguousAcks(t *testing.T) {
+	var saved []int64
+	tracker := newOffsetTracker("test.log", 0, func(_ string, offset int64) {
+		saved = append(saved, offset)
+	})
+	tracker.interval = 0
+
+	first := tracker.track(10)
+	second := tracker.track(20)
+	third := tracker.track(30)
+
+	second.Ack()
+	if len(saved) != 0 {
+		t.Fatalf("expected no commit while the first line is pending, got %v", saved)
+	}
+
+	first.Ack()
+	if len(saved) != 1 || saved[0] != 20 {
+		t.Fatalf("expected commit at 20, got %v", saved)
+	}
+
+	third.Ack()
+	if saved[len(saved)-1] != 30 {
+		t.Errorf("expected commit at 30, got %v", saved)
+	}
+}
+
+func TestOffsetTracker_HoldsOffsetAtFailedLine(t *testing.T) {
+	var saved []int64
+	tracker := newOffsetTracker("test.log", 5, func(_ string, offset int64) {
+		saved = append(saved, offset)
+	})
+	tracker.interval = 0
+	tracker.retryDelay = 0
+
+	first := tracker.track(10)
+	second := tracker.track(20)
+	third := tracker.track(30)
+
+	first.Ack()
+	second.Nack()
+	third.Ack()
+	if len(saved) != 1 || saved[0] != 10 {
+		t.Fatalf("expected the offset held before the failed line at 10, got %v", saved)
+	}
+	if !tracker.blocked() {
+		t.Error("expected the tracker to block reading after a failure")
+	}
+	if from, ok := tracker.retry(); !ok || from != 10 {
+		t.Errorf("expected a retry from 10, got %d (%v)", from, ok)
+	}
+}
+
+func TestOffsetTracker_WaitsBeforeRetry(t *testing.T) {
+	tracker := newOffsetTracker("test.log", 0, func(string, int64) {})
+	tracker.retryDelay = time.Hour
+
+	tracker.track(10).Nack()
+	if _, ok := tracker.retry(); ok {
+		t.Error("expected no retry before the retry delay has passed")
+	}
+}
+
+func TestOffsetTracker_BlocksWhenFull(t *testing.T) {
+	tracker := newOffsetTracker("test.log", 0, func(string, int64) {})
+	tracker.limit = 2
+
+	first := tracker.track(10)
+	tracker.track(20)
+	if !tracker.blocked() {
+		t.Fatal("expected the tracker to block reading at the limit")
+	}
+	first.Ack()
+	if tracker.blocked() {
+		t.Error("expected reading to resume once a line is acknowledged")
+	}
+}
+
+func TestOffsetTracker_ThrottlesSaves(t *testing.T) {
+	saved := make(chan int64, 10)
+	tracker := newOffsetTracker("test.log", 0, func(_ string, offset int64) {
+		saved <- offset
+	})
+	tracker.interval = 50 * time.Millisecond
+
+	tracker.track(10).Ack()
+	tracker.track(20).Ack()
+	tracker.track(30).Ack()
+	if got := <-saved; got != 10 {
+		t.Fatalf("expected the first commit saved right away at 10, got %d", got)
+	}
+	select {
+	case got := <-saved:
+		if got != 30 {
+			t.Errorf("expected the later commits saved once at 30, got %d", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the later commits to be saved")
+	}
+	if len(saved) != 0 {
+		t.Errorf("expected a single delayed save, got %d more", len(saved))
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package file
+
+import "os"
+
+// fileID is not available without inodes; checkpoints are keyed by path.
+func fileID(info os.FileInfo) (dev, inode uint64, ok bool) {
+	return 0, 0, false
+}
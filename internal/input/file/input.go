@@ -1,10 +1,161 @@
 package file
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
 
-type HandlerFunc func(event map[string]interface{})
+	"github.com/kpiljoong/flox/internal/ack"
+	"github.com/kpiljoong/flox/internal/input"
+	"github.com/kpiljoong/flox/internal/plugin"
+)
 
-func StartFile(ctx context.Context, path string, handle HandlerFunc, namespace string, trackOffset bool, startFrom string) {
-	tailer := NewTailer(path, namespace, trackOffset, startFrom)
-	tailer.Run(ctx, handle)
+// podSyncTimeout bounds how long the input waits for the first pod list
+// before it starts tailing.
+const podSyncTimeout = 10 * time.Second
+
+func init() {
+	input.Register("file", plugin.Typed("Tails log files matching a glob pattern",
+		func(ctx context.Context, cfg Config) (input.Input, error) {
+			return &fileInput{cfg: cfg, pipeline: input.PipelineName(ctx), id: input.InputID(ctx)}, nil
+		}))
+}
+
+// Config configures the file input. Format selects how lines are framed:
+// "cri", "docker" (json-file), "json" (one JSON object per line) or "auto",
+// the default, which recognises each of them per line. DecodeJSON, on by
+// default, turns a JSON message into event fields; otherwise the message is
+// kept as text in the "message" field. Lines that cannot be parsed, such as
+// plain text when JSON is expected, are skipped unless RawText is set, in
+// which case they become events with the text in "message" and the source
+// file in "file".
+//
+// Long lines that the runtime split into partial chunks are joined again up
+// to MaxLineSize bytes (default 1 MiB). A message whose final chunk has not
+// arrived within PartialTimeout (default 5s) is emitted as it is.
+//
+// Multiline, when a pattern is set, then joins consecutive messages of a file
+// into one, which is kept as text rather than decoded; see MultilineConfig.
+// Events from pod log files carry the namespace, pod and container taken
+// from the file's path; see KubernetesConfig.
+//
+// Include and Exclude select the pod log files to tail by namespace, pod and
+// container name; see MatchRules. Without Exclude, DefaultExclude applies.
+// Namespace is kept as a shorthand for including a single namespace. Files
+// that are not pod logs are not tailed.
+//
+// New files and new lines are picked up through filesystem notifications
+// (inotify on Linux) unless Watch is false. ScanInterval (default 10s) sets
+// how often the path is globbed for new files and ReadInterval (default 1s)
+// how often a file at its end is checked for new lines when notifications
+// are off or unavailable; with notifications, files are still checked every
+// ScanInterval in case an event was missed.
+//
+// A rotated file is read to its end before the tailer moves on to the file
+// that replaced it, and is then followed for RotateWait (default 5s) more
+// for writers that still have it open. Rotated files renamed to a path that
+// matches the pattern, such as app.log.1, are not read a second time.
+//
+// With TrackOffset, the position reached in each file is checkpointed by
+// device and inode together with a hash of its first FingerprintBytes
+// (default 1024), so a renamed file resumes where it was and a file created
+// at the path of a rotated or deleted one is read from its start, whatever
+// StartFrom says. Checkpoints are kept per pipeline and input. State files
+// that hold offsets by path, as written by earlier versions, are migrated on
+// startup. Offsets
+// only move past events the outputs acknowledged: after a failed delivery the
+// file is read again from its offset, and reading pauses while too many
+// events await acknowledgement.
+type Config struct {
+	Path             string           `mapstructure:"path"`
+	Namespace        string           `mapstructure:"namespace"`
+	TrackOffset      bool             `mapstructure:"track_offset"`
+	StartFrom        string           `mapstructure:"start_from"`
+	Format           string           `mapstructure:"format"`
+	DecodeJSON       *bool            `mapstructure:"decode_json"`
+	RawText          bool             `mapstructure:"raw_text"`
+	MaxLineSize      int              `mapstructure:"max_line_size"`
+	PartialTimeout   time.Duration    `mapstructure:"partial_timeout"`
+	Multiline        MultilineConfig  `mapstructure:"multiline"`
+	Kubernetes       KubernetesConfig `mapstructure:"kubernetes"`
+	Include          MatchRules       `mapstructure:"include"`
+	Exclude          *MatchRules      `mapstructure:"exclude"`
+	Watch            *bool            `mapstructure:"watch"`
+	ScanInterval     time.Duration    `mapstructure:"scan_interval"`
+	ReadInterval     time.Duration    `mapstructure:"read_interval"`
+	RotateWait       time.Duration    `mapstructure:"rotate_wait"`
+	FingerprintBytes int              `mapstructure:"fingerprint_bytes"`
+}
+
+func (c Config) Validate() error {
+	if c.Path == "" {
+		return errors.New("path not specified for file input")
+	}
+	switch c.Format {
+	case "", FormatAuto, FormatCRI, FormatDocker, FormatJSON:
+	default:
+		return fmt.Errorf("unsupported format %q (expected auto, cri, docker or json)", c.Format)
+	}
+	if c.MaxLineSize < 0 {
+		return errors.New("max_line_size must not be negative")
+	}
+	if c.PartialTimeout < 0 {
+		return errors.New("partial_timeout must not be negative")
+	}
+	if c.ScanInterval < 0 || c.ReadInterval < 0 || c.RotateWait < 0 {
+		return errors.New("scan_interval, read_interval and rotate_wait must not be negative")
+	}
+	if c.FingerprintBytes < 0 {
+		return errors.New("fingerprint_bytes must not be negative")
+	}
+	if _, err := newFileRules(c.Include, c.Exclude, c.Namespace); err != nil {
+		return err
+	}
+	return c.Multiline.Validate()
 }
+
+type fileInput struct {
+	cfg      Config
+	pipeline string
+	id       string
+}
+
+func (i *fileInput) Run(ctx context.Context, handle input.HandlerFunc) {
+	tailer := NewTailer(i.cfg.Path, i.cfg.Namespace, i.cfg.TrackOffset, i.cfg.StartFrom)
+	tailer.pipeline = i.pipeline
+	tailer.id = i.id
+	// Validate has already compiled the rules.
+	tailer.rules, _ = newFileRules(i.cfg.Include, i.cfg.Exclude, i.cfg.Namespace)
+	tailer.parser = newLineParser(i.cfg.Format, i.cfg.DecodeJSON == nil || *i.cfg.DecodeJSON)
+	tailer.parser.rawText = i.cfg.RawText
+	tailer.maxLineSize = i.cfg.MaxLineSize
+	tailer.partialTimeout = i.cfg.PartialTimeout
+	tailer.multiline = i.cfg.Multiline
+	tailer.kubernetes = i.cfg.Kubernetes
+	tailer.watch = i.cfg.Watch == nil || *i.cfg.Watch
+	tailer.scanInterval = i.cfg.ScanInterval
+	tailer.readInterval = i.cfg.ReadInterval
+	tailer.rotateWait = i.cfg.RotateWait
+	tailer.fingerprintBytes = i.cfg.FingerprintBytes
+	if i.cfg.Kubernetes.API.Enabled {
+		pods, err := newPodCache(ctx, i.cfg.Kubernetes.API)
+		if err != nil {
+			log.Printf("[Kubernetes] Pod metadata unavailable: %v", err)
+		} else {
+			syncCtx, cancel := context.WithTimeout(ctx, podSyncTimeout)
+			if !pods.WaitForSync(syncCtx) {
+				log.Printf("[Kubernetes] Pods not listed after %s, tailing without their metadata for now", podSyncTimeout)
+			}
+			cancel()
+			tailer.pods = pods
+		}
+	}
+	tailer.Run(ctx, HandlerFunc(handle))
+}
+
+// HandlerFunc receives each event read from a file. When offsets are tracked
+// tok is non-nil and the offset past the event is only saved once it is
+// acked; once it is nacked, the file is read again from the saved offset.
+type HandlerFunc func(event map[string]interface{}, tok *ack.Token)
@@ -0,0 +1,252 @@
+package file
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kpiljoong/flox/internal/fieldpath"
+	"github.com/kpiljoong/flox/internal/kubernetes"
+)
+
+// KubernetesConfig controls the Kubernetes metadata added to events read
+// from the kubelet's pod log directory:
+//
+//	/var/log/pods/<namespace>_<pod>_<uid>/<container>/<restart count>.log
+//
+// Enrichment is on by default and only applies to files laid out like this.
+// Fields holds the dot path each value is stored under; a path of "-" leaves
+// the value out. With API enabled, the pod's labels, annotations, node and
+// owning workload are looked up as well; see KubernetesAPIConfig.
+type KubernetesConfig struct {
+	Enabled *bool               `mapstructure:"enabled"`
+	Fields  KubernetesFields    `mapstructure:"fields"`
+	API     KubernetesAPIConfig `mapstructure:"api"`
+}
+
+type KubernetesFields struct {
+	Namespace     string `mapstructure:"namespace"`
+	PodName       string `mapstructure:"pod_name"`
+	PodUID        string `mapstructure:"pod_uid"`
+	ContainerName string `mapstructure:"container_name"`
+	RestartCount  string `mapstructure:"restart_count"`
+	NodeName      string `mapstructure:"node_name"`
+	Labels        string `mapstructure:"labels"`
+	Annotations   string `mapstructure:"annotations"`
+	OwnerKind     string `mapstructure:"owner_kind"`
+	OwnerName     string `mapstructure:"owner_name"`
+}
+
+// KubernetesAPIConfig enables pod metadata from the Kubernetes API. Pods are
+// watched and cached by UID, using the pod's service account unless Host
+// points elsewhere, such as at kubectl proxy. Only pods on NodeName, which
+// defaults to the NODE_NAME environment variable, are watched.
+//
+// Events of pods annotated with flox.io/exclude: "true" are dropped.
+type KubernetesAPIConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Host     string `mapstructure:"host"`
+	NodeName string `mapstructure:"node_name"`
+}
+
+// DefaultKubernetesFields are the paths used for fields that are not set.
+var DefaultKubernetesFields = KubernetesFields{
+	Namespace:     "kubernetes.namespace",
+	PodName:       "kubernetes.pod_name",
+	PodUID:        "kubernetes.pod_uid",
+	ContainerName: "kubernetes.container_name",
+	RestartCount:  "kubernetes.restart_count",
+	NodeName:      "kubernetes.node_name",
+	Labels:        "kubernetes.labels",
+	Annotations:   "kubernetes.annotations",
+	OwnerKind:     "kubernetes.owner_kind",
+	OwnerName:     "kubernetes.owner_name",
+}
+
+func (c KubernetesConfig) enabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+// withDefaults fills in the default path of every field that is not set.
+func (f KubernetesFields) withDefaults() KubernetesFields {
+	def := DefaultKubernetesFields
+	pick := func(v, d string) string {
+		if v == "" {
+			return d
+		}
+		return v
+	}
+	return KubernetesFields{
+		Namespace:     pick(f.Namespace, def.Namespace),
+		PodName:       pick(f.PodName, def.PodName),
+		PodUID:        pick(f.PodUID, def.PodUID),
+		ContainerName: pick(f.ContainerName, def.ContainerName),
+		RestartCount:  pick(f.RestartCount, def.RestartCount),
+		NodeName:      pick(f.NodeName, def.NodeName),
+		Labels:        pick(f.Labels, def.Labels),
+		Annotations:   pick(f.Annotations, def.Annotations),
+		OwnerKind:     pick(f.OwnerKind, def.OwnerKind),
+		OwnerName:     pick(f.OwnerName, def.OwnerName),
+	}
+}
+
+// podLog is what a pod log file's path says about the container it belongs
+// to.
+type podLog struct {
+	namespace    string
+	podName      string
+	podUID       string
+	container    string
+	restartCount int
+}
+
+// parsePodLogPath reads the pod and container from a path in the kubelet's
+// pod log directory. Rotated files such as "0.log.20240501-101500" keep the
+// restart count of the file they were rotated from.
+func parsePodLogPath(filePath string) (podLog, bool) {
+	dir := filepath.Dir(filePath)
+	container := filepath.Base(dir)
+	podDir := filepath.Base(filepath.Dir(dir))
+
+	parts := strings.SplitN(podDir, "_", 3)
+	if len(parts) < 3 {
+		return podLog{}, false
+	}
+	p := podLog{
+		namespace: parts[0],
+		podName:   parts[1],
+		podUID:    parts[2],
+		container: container,
+	}
+
+	name := filepath.Base(filePath)
+	if i := strings.Index(name, ".log"); i > 0 {
+		if n, err := strconv.Atoi(name[:i]); err == nil {
+			p.restartCount = n
+		}
+	}
+	return p, true
+}
+
+// metaField is a value added to every event of a file.
+type metaField struct {
+	path  string
+	value interface{}
+}
+
+// podMetadata adds the metadata of the pod a file belongs to to its
+// events.
+type podMetadata struct {
+	path   string
+	uid    string
+	fields KubernetesFields
+	static []metaField
+	pods   *kubernetes.PodCache // nil unless the API is enabled
+
+	excludedLogged bool
+}
+
+// newPodMetadata returns nil if enrichment is off or filePath is not a pod
+// log.
+func newPodMetadata(filePath string, cfg KubernetesConfig, pods *kubernetes.PodCache) *podMetadata {
+	if !cfg.enabled() {
+		return nil
+	}
+	p, ok := parsePodLogPath(filePath)
+	if !ok {
+		return nil
+	}
+
+	fields := cfg.Fields.withDefaults()
+	var static []metaField
+	static = addMeta(static, fields.Namespace, p.namespace)
+	static = addMeta(static, fields.PodName, p.podName)
+	static = addMeta(static, fields.PodUID, p.podUID)
+	static = addMeta(static, fields.ContainerName, p.container)
+	static = addMeta(static, fields.RestartCount, p.restartCount)
+	return &podMetadata{path: filePath, uid: p.podUID, fields: fields, static: static, pods: pods}
+}
+
+func addMeta(meta []metaField, path string, value interface{}) []metaField {
+	if path == "-" {
+		return meta
+	}
+	return append(meta, metaField{path: path, value: value})
+}
+
+// apply stores the pod's metadata in event, replacing fields of the same
+// name. It reports false if the pod opted out of log collection and the
+// event should be dropped.
+func (m *podMetadata) apply(event map[string]interface{}) bool {
+	enrich(event, m.static)
+	if m.pods == nil {
+		return true
+	}
+	pod, ok := m.pods.Get(m.uid)
+	if !ok {
+		return true
+	}
+	if pod.Excluded() {
+		if !m.excludedLogged {
+			log.Printf("[Tailing] Pod %s/%s is annotated %s, dropping events from %s",
+				pod.Namespace, pod.Name, kubernetes.ExcludeAnnotation, m.path)
+			m.excludedLogged = true
+		}
+		return false
+	}
+	enrich(event, apiMetadata(pod, m.fields))
+	return true
+}
+
+// apiMetadata returns the fields taken from the pod in the API. Labels and
+// annotations are copied, as filters may change them.
+func apiMetadata(pod *kubernetes.Pod, fields KubernetesFields) []metaField {
+	var meta []metaField
+	meta = addMeta(meta, fields.NodeName, pod.NodeName)
+	meta = addMeta(meta, fields.Labels, stringMap(pod.Labels))
+	meta = addMeta(meta, fields.Annotations, stringMap(pod.Annotations))
+	if pod.OwnerKind != "" {
+		meta = addMeta(meta, fields.OwnerKind, pod.OwnerKind)
+		meta = addMeta(meta, fields.OwnerName, pod.OwnerName)
+	}
+	return meta
+}
+
+func stringMap(m map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func enrich(event map[string]interface{}, meta []metaField) {
+	for _, m := range meta {
+		fieldpath.Set(event, m.path, m.value)
+	}
+}
+
+// newPodCache starts watching the pods of the node for cfg until ctx is
+// done.
+func newPodCache(ctx context.Context, cfg KubernetesAPIConfig) (*kubernetes.PodCache, error) {
+	var (
+		client *kubernetes.Client
+		err    error
+	)
+	if cfg.Host != "" {
+		client = kubernetes.NewClient(cfg.Host, "", nil)
+	} else if client, err = kubernetes.InClusterClient(); err != nil {
+		return nil, err
+	}
+
+	node := cfg.NodeName
+	if node == "" {
+		node = os.Getenv("NODE_NAME")
+	}
+	pods := kubernetes.NewPodCache(client, node)
+	go pods.Run(ctx)
+	return pods, nil
+}
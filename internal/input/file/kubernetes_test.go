@@ -0,0 +1,139 @@
+package file
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testPodLog = "/var/log/pods/shop_cart-7d9f_6a1b2c3d-0000-4e5f/server/2.log"
+
+func TestParsePodLogPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want podLog
+		ok   bool
+	}{
+		{testPodLog, podLog{"shop", "cart-7d9f", "6a1b2c3d-0000-4e5f", "server", 2}, true},
+		{"/var/log/pods/shop_cart_uid/server/0.log.20240501-101500", podLog{"shop", "cart", "uid", "server", 0}, true},
+		{"/var/log/pods/shop_cart_uid/server/1.log.20240501-101500.gz", podLog{"shop", "cart", "uid", "server", 1}, true},
+		{"/var/log/app/server.log", podLog{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parsePodLogPath(tt.path)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parsePodLogPath(%q) = %+v, %v; want %+v, %v", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestKubernetesMetadata(t *testing.T) {
+	event := map[string]interface{}{"msg": "hi"}
+	newPodMetadata(testPodLog, KubernetesConfig{}, nil).apply(event)
+
+	want := map[string]interface{}{
+		"msg": "hi",
+		"kubernetes": map[string]interface{}{
+			"namespace":      "shop",
+			"pod_name":       "cart-7d9f",
+			"pod_uid":        "6a1b2c3d-0000-4e5f",
+			"container_name": "server",
+			"restart_count":  2,
+		},
+	}
+	if !reflect.DeepEqual(event, want) {
+		t.Errorf("unexpected event:\n got %v\nwant %v", event, want)
+	}
+}
+
+func TestKubernetesMetadataCustomFields(t *testing.T) {
+	cfg := KubernetesConfig{Fields: KubernetesFields{
+		Namespace:    "ns",
+		PodName:      "k8s.pod",
+		PodUID:       "-",
+		RestartCount: "-",
+	}}
+	event := map[string]interface{}{}
+	newPodMetadata(testPodLog, cfg, nil).apply(event)
+
+	want := map[string]interface{}{
+		"ns":  "shop",
+		"k8s": map[string]interface{}{"pod": "cart-7d9f"},
+		"kubernetes": map[string]interface{}{
+			"container_name": "server",
+		},
+	}
+	if !reflect.DeepEqual(event, want) {
+		t.Errorf("unexpected event:\n got %v\nwant %v", event, want)
+	}
+}
+
+func TestKubernetesMetadataDisabled(t *testing.T) {
+	off := false
+	if meta := newPodMetadata(testPodLog, KubernetesConfig{Enabled: &off}, nil); meta != nil {
+		t.Errorf("expected no metadata when disabled, got %v", meta)
+	}
+	if meta := newPodMetadata("/var/log/app/server.log", KubernetesConfig{}, nil); meta != nil {
+		t.Errorf("expected no metadata for a file outside the pod log directory, got %v", meta)
+	}
+}
+
+// servePods answers the pod list with pods and holds watches open.
+func servePods(t *testing.T, pods string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "true" {
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte(`{"metadata":{"resourceVersion":"1"},"items":[` + pods + `]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPodMetadataFromAPI(t *testing.T) {
+	srv := servePods(t, `
+		{"metadata":{"uid":"6a1b2c3d-0000-4e5f","namespace":"shop","name":"cart-7d9f",
+			"labels":{"app":"cart","pod-template-hash":"7d9f"},
+			"annotations":{"team":"checkout"},
+			"ownerReferences":[{"kind":"ReplicaSet","name":"cart-7d9f","controller":true}]},
+		 "spec":{"nodeName":"node-1"}},
+		{"metadata":{"uid":"quiet-uid","namespace":"shop","name":"quiet",
+			"annotations":{"flox.io/exclude":"true"}}}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pods, err := newPodCache(ctx, KubernetesAPIConfig{Enabled: true, Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncCtx, syncCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer syncCancel()
+	if !pods.WaitForSync(syncCtx) {
+		t.Fatal("pods not listed")
+	}
+
+	cfg := KubernetesConfig{Fields: KubernetesFields{Labels: "labels"}}
+	event := map[string]interface{}{}
+	if !newPodMetadata(testPodLog, cfg, pods).apply(event) {
+		t.Fatal("expected event to be kept")
+	}
+	if got := event["labels"]; !reflect.DeepEqual(got, map[string]interface{}{"app": "cart", "pod-template-hash": "7d9f"}) {
+		t.Errorf("unexpected labels: %v", got)
+	}
+	k8s := event["kubernetes"].(map[string]interface{})
+	if k8s["node_name"] != "node-1" || k8s["owner_kind"] != "Deployment" || k8s["owner_name"] != "cart" {
+		t.Errorf("unexpected API metadata: %v", k8s)
+	}
+	if got := k8s["annotations"]; !reflect.DeepEqual(got, map[string]interface{}{"team": "checkout"}) {
+		t.Errorf("unexpected annotations: %v", got)
+	}
+
+	quiet := newPodMetadata("/var/log/pods/shop_quiet_quiet-uid/app/0.log", KubernetesConfig{}, pods)
+	if quiet.apply(map[string]interface{}{}) {
+		t.Error("expected events of an excluded pod to be dropped")
+	}
+}
@@ -0,0 +1,163 @@
+package file
+
+import (
+	"log"
+	"time"
+
+	"github.com/kpiljoong/flox/internal/ack"
+	"github.com/kpiljoong/flox/internal/metrics"
+)
+
+// lineHandler turns the lines read from one file into events: it parses the
+// runtime framing, reassembles partial lines, joins multiline messages and
+// resolves offsets.
+type lineHandler struct {
+	pipeline string // the pipeline fed, used as metric label
+	input    string // the input's path pattern, used as metric label
+	path     string
+	parser   *lineParser
+	asm      *assembler
+	agg      *aggregator  // nil unless multiline is configured
+	meta     *podMetadata // nil unless the file is a pod log
+	tracker  *offsetTracker
+	handler  HandlerFunc
+	warned   bool
+}
+
+// line handles one complete line ending at offset end.
+func (h *lineHandler) line(line []byte, end int64, now time.Time) {
+	rec, err := h.parser.parse(line)
+	if err != nil {
+		h.flushPending("unparsable line", now)
+		h.flushMultiline()
+		h.skip(line, end, err)
+		return
+	}
+
+	if h.asm.pending() && !h.asm.belongs(rec) {
+		h.flushPending("chunk from another stream", now)
+	}
+	if !rec.partial && !h.asm.pending() {
+		h.complete(rec, end, now)
+		return
+	}
+
+	if flushed, flushedEnd, ok := h.asm.add(rec, end, now); ok {
+		log.Printf("[Tailing] Line in %s exceeds %d bytes, splitting it", h.path, h.asm.maxSize)
+		h.complete(flushed, flushedEnd, now)
+	}
+	if !rec.partial {
+		complete, completeEnd, _ := h.asm.flush()
+		h.complete(complete, completeEnd, now)
+	}
+}
+
+// idle is called while waiting for more data. It flushes a partial line
+// whose final chunk has not arrived in time and a multiline message that has
+// not been continued in time.
+func (h *lineHandler) idle(now time.Time) {
+	if h.asm.expired(now) {
+		h.flushPending("timed out waiting for the final chunk", now)
+	}
+	if h.agg != nil && h.agg.expired(now) {
+		h.flushMultiline()
+	}
+}
+
+// complete handles a line whose partial chunks, if any, have been joined.
+func (h *lineHandler) complete(rec record, end int64, now time.Time) {
+	if h.agg == nil {
+		h.emit(rec, end)
+		return
+	}
+	for _, p := range h.agg.add(rec, end, now) {
+		h.emit(p.rec, p.end)
+	}
+}
+
+// pending reports whether a partial or multiline message is waiting for
+// more lines.
+func (h *lineHandler) pending() bool {
+	return h.asm.pending() || (h.agg != nil && h.agg.active)
+}
+
+// flush emits every message that is waiting for more lines.
+func (h *lineHandler) flush(reason string) {
+	h.flushPending(reason, time.Now())
+	h.flushMultiline()
+}
+
+// reset discards every message that is waiting for more lines, when the
+// file is about to be read again from an earlier offset.
+func (h *lineHandler) reset() {
+	h.asm = newAssembler(h.asm.maxSize, h.asm.timeout)
+	if h.agg != nil {
+		h.agg.flush()
+	}
+}
+
+func (h *lineHandler) flushPending(reason string, now time.Time) {
+	if rec, end, ok := h.asm.flush(); ok {
+		log.Printf("[Tailing] Flushing incomplete line in %s: %s", h.path, reason)
+		h.complete(rec, end, now)
+	}
+}
+
+func (h *lineHandler) flushMultiline() {
+	if h.agg == nil {
+		return
+	}
+	for _, p := range h.agg.flush() {
+		h.emit(p.rec, p.end)
+	}
+}
+
+func (h *lineHandler) emit(rec record, end int64) {
+	tok := h.track(end)
+	event, err := h.parser.event(rec)
+	if err != nil {
+		h.unparsed(rec, tok, err)
+		return
+	}
+	h.warned = false
+	h.handle(event, tok)
+}
+
+// skip handles a line whose framing could not be parsed.
+func (h *lineHandler) skip(line []byte, end int64, err error) {
+	h.unparsed(record{body: trimNewline(line)}, h.track(end), err)
+}
+
+// unparsed either emits rec as a raw text event or skips it.
+func (h *lineHandler) unparsed(rec record, tok *ack.Token, err error) {
+	if h.parser.rawText {
+		metrics.UnparsedLines.WithLabelValues(h.pipeline, h.input, "raw").Inc()
+		h.handle(h.parser.raw(rec, h.path), tok)
+		return
+	}
+	metrics.UnparsedLines.WithLabelValues(h.pipeline, h.input, "skipped").Inc()
+	h.warn(rec.body, err)
+	tok.Ack()
+}
+
+func (h *lineHandler) handle(event map[string]interface{}, tok *ack.Token) {
+	if h.meta != nil && !h.meta.apply(event) {
+		tok.Ack()
+		return
+	}
+	h.handler(event, tok)
+}
+
+func (h *lineHandler) warn(line []byte, err error) {
+	if !h.warned {
+		log.Printf("[Tailing] Skipping unparsable line in %s (%v): %s", h.path, err, string(line))
+		h.warned = true
+	}
+}
+
+func (h *lineHandler) track(end int64) *ack.Token {
+	if h.tracker == nil {
+		return nil
+	}
+	return h.tracker.track(end)
+}
@@ -0,0 +1,151 @@
+package file
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/kpiljoong/flox/internal/ack"
+	"github.com/kpiljoong/flox/internal/metrics"
+)
+
+func newTestLineHandler(maxSize int, timeout time.Duration) (*lineHandler, *[]map[string]interface{}) {
+	var events []map[string]interface{}
+	return &lineHandler{
+		path:   "test.log",
+		parser: newLineParser(FormatCRI, true),
+		asm:    newAssembler(maxSize, timeout),
+		handler: func(event map[string]interface{}, _ *ack.Token) {
+			events = append(events, event)
+		},
+	}, &events
+}
+
+func TestLineHandlerJoinsPartialLines(t *testing.T) {
+	h, events := newTestLineHandler(0, 0)
+	now := time.Now()
+
+	h.line([]byte(`2024-05-01T10:00:00Z stdout P {"msg":"a very `), 10, now)
+	h.line([]byte(`2024-05-01T10:00:01Z stdout P long `), 20, now)
+	if len(*events) != 0 {
+		t.Fatalf("expected no event before the final chunk, got %v", *events)
+	}
+	h.line([]byte(`2024-05-01T10:00:02Z stdout F line"}`), 30, now)
+
+	if len(*events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(*events))
+	}
+	event := (*events)[0]
+	if event["msg"] != "a very long line" {
+		t.Errorf("expected reassembled message, got %v", event["msg"])
+	}
+	if event[TimeField] != "2024-05-01T10:00:00Z" {
+		t.Errorf("expected time of the first chunk, got %v", event[TimeField])
+	}
+}
+
+func TestLineHandlerCommitsOffsetAfterFinalChunk(t *testing.T) {
+	var saved []int64
+	h, _ := newTestLineHandler(0, 0)
+	h.tracker = newOffsetTracker("test.log", 0, func(_ string, offset int64) {
+		saved = append(saved, offset)
+	})
+	h.handler = func(_ map[string]interface{}, tok *ack.Token) {
+		tok.Ack()
+	}
+
+	h.line([]byte(`2024-05-01T10:00:00Z stdout P {"msg":`), 10, time.Now())
+	if len(saved) != 0 {
+		t.Fatalf("expected no offset saved for a partial chunk, got %v", saved)
+	}
+	h.line([]byte(`2024-05-01T10:00:00Z stdout F "x"}`), 20, time.Now())
+	if len(saved) != 1 || saved[0] != 20 {
+		t.Errorf("expected offset 20 after the final chunk, got %v", saved)
+	}
+}
+
+func TestLineHandlerMaxSize(t *testing.T) {
+	h, events := newTestLineHandler(10, 0)
+	h.parser = newLineParser(FormatCRI, false)
+	now := time.Now()
+
+	h.line([]byte(`2024-05-01T10:00:00Z stdout P 0123456789`), 10, now)
+	h.line([]byte(`2024-05-01T10:00:00Z stdout F abc`), 20, now)
+
+	if len(*events) != 2 {
+		t.Fatalf("expected oversized line to be split in 2 events, got %v", *events)
+	}
+	if (*events)[0][MessageField] != "0123456789" || (*events)[1][MessageField] != "abc" {
+		t.Errorf("unexpected messages: %v", *events)
+	}
+}
+
+func TestLineHandlerFlushesOnTimeout(t *testing.T) {
+	h, events := newTestLineHandler(0, time.Second)
+	h.parser = newLineParser(FormatCRI, false)
+	start := time.Now()
+
+	h.line([]byte(`2024-05-01T10:00:00Z stdout P never finished`), 10, start)
+	h.idle(start.Add(500 * time.Millisecond))
+	if len(*events) != 0 {
+		t.Fatalf("expected partial line to be held before the timeout, got %v", *events)
+	}
+
+	h.idle(start.Add(2 * time.Second))
+	if len(*events) != 1 || (*events)[0][MessageField] != "never finished" {
+		t.Errorf("expected incomplete line to be flushed, got %v", *events)
+	}
+}
+
+func TestLineHandlerRawText(t *testing.T) {
+	h, events := newTestLineHandler(0, 0)
+	h.pipeline = "logs"
+	h.input = "raw-test/*.log"
+	h.parser = newLineParser(FormatCRI, true)
+	h.parser.rawText = true
+	raw := metrics.UnparsedLines.WithLabelValues("logs", "raw-test/*.log", "raw")
+	before := testutil.ToFloat64(raw)
+	now := time.Now()
+
+	h.line([]byte(`2024-05-01T10:00:00Z stdout F 127.0.0.1 - "GET / HTTP/1.1" 200`), 10, now)
+	h.line([]byte("level=info msg=started\n"), 20, now)
+
+	if len(*events) != 2 {
+		t.Fatalf("expected 2 raw events, got %v", *events)
+	}
+	first := (*events)[0]
+	if first[MessageField] != `127.0.0.1 - "GET / HTTP/1.1" 200` || first[StreamField] != "stdout" || first[FileField] != "test.log" {
+		t.Errorf("unexpected raw event for a CRI line: %v", first)
+	}
+	if second := (*events)[1]; second[MessageField] != "level=info msg=started" || second[FileField] != "test.log" {
+		t.Errorf("unexpected raw event for an unframed line: %v", second)
+	}
+	if got := testutil.ToFloat64(raw) - before; got != 2 {
+		t.Errorf("expected 2 lines counted as raw, got %v", got)
+	}
+}
+
+func TestLineHandlerSkipsUnparsedLines(t *testing.T) {
+	var saved []int64
+	h, events := newTestLineHandler(0, 0)
+	h.pipeline = "logs"
+	h.input = "skip-test/*.log"
+	h.tracker = newOffsetTracker("test.log", 0, func(_ string, offset int64) {
+		saved = append(saved, offset)
+	})
+	skipped := metrics.UnparsedLines.WithLabelValues("logs", "skip-test/*.log", "skipped")
+	before := testutil.ToFloat64(skipped)
+
+	h.line([]byte("2024-05-01T10:00:00Z stdout F not json"), 10, time.Now())
+
+	if len(*events) != 0 {
+		t.Errorf("expected the line to be skipped, got %v", *events)
+	}
+	if len(saved) != 1 || saved[0] != 10 {
+		t.Errorf("expected the skipped line to be committed, got %v", saved)
+	}
+	if got := testutil.ToFloat64(skipped) - before; got != 1 {
+		t.Errorf("expected 1 line counted as skipped, got %v", got)
+	}
+}
@@ -0,0 +1,170 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	DefaultMultilineMaxLines = 500
+	DefaultMultilineTimeout  = 2 * time.Second
+)
+
+// MultilineConfig joins consecutive lines, such as the frames of a stack
+// trace, into one event. Exactly one pattern is set:
+//
+//   - StartPattern matches the first line of an event; every line that does
+//     not match is appended to the event before it.
+//   - ContinuationPattern matches lines that belong to the event before
+//     them; every line that does not match starts a new event.
+//
+// An event is emitted once the next one starts, once it holds MaxLines lines
+// (default 500) or when no line has arrived for Timeout (default 2s). An
+// event of several lines is kept as text in the message field, even with
+// JSON decoding on.
+type MultilineConfig struct {
+	StartPattern        string        `mapstructure:"start_pattern"`
+	ContinuationPattern string        `mapstructure:"continuation_pattern"`
+	MaxLines            int           `mapstructure:"max_lines"`
+	Timeout             time.Duration `mapstructure:"timeout"`
+}
+
+func (c MultilineConfig) enabled() bool {
+	return c.StartPattern != "" || c.ContinuationPattern != ""
+}
+
+func (c MultilineConfig) Validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if c.StartPattern != "" && c.ContinuationPattern != "" {
+		return errors.New("multiline needs either start_pattern or continuation_pattern, not both")
+	}
+	if _, err := c.compile(); err != nil {
+		return err
+	}
+	if c.MaxLines < 0 {
+		return errors.New("multiline max_lines must not be negative")
+	}
+	if c.Timeout < 0 {
+		return errors.New("multiline timeout must not be negative")
+	}
+	return nil
+}
+
+func (c MultilineConfig) compile() (*regexp.Regexp, error) {
+	pattern, name := c.StartPattern, "start_pattern"
+	if pattern == "" {
+		pattern, name = c.ContinuationPattern, "continuation_pattern"
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("multiline %s: %w", name, err)
+	}
+	return re, nil
+}
+
+// pendingRecord is a record that is ready to be emitted, ending at end.
+type pendingRecord struct {
+	rec record
+	end int64
+}
+
+// aggregator implements MultilineConfig for one file.
+type aggregator struct {
+	pattern      *regexp.Regexp
+	continuation bool
+	maxLines     int
+	timeout      time.Duration
+
+	active  bool
+	rec     record
+	lines   int
+	end     int64
+	updated time.Time
+}
+
+// newAggregator returns nil when multiline is not configured.
+func newAggregator(cfg MultilineConfig) (*aggregator, error) {
+	if !cfg.enabled() {
+		return nil, nil
+	}
+	re, err := cfg.compile()
+	if err != nil {
+		return nil, err
+	}
+	a := &aggregator{
+		pattern:      re,
+		continuation: cfg.ContinuationPattern != "",
+		maxLines:     cfg.MaxLines,
+		timeout:      cfg.Timeout,
+	}
+	if a.maxLines <= 0 {
+		a.maxLines = DefaultMultilineMaxLines
+	}
+	if a.timeout <= 0 {
+		a.timeout = DefaultMultilineTimeout
+	}
+	return a, nil
+}
+
+// add feeds the next line, ending at end, and returns the events it
+// completed, in order.
+func (a *aggregator) add(rec record, end int64, now time.Time) []pendingRecord {
+	var done []pendingRecord
+	if a.active && !a.continues(rec) {
+		done = append(done, a.flushRecord())
+	}
+
+	if a.active {
+		a.rec.body = append(append(a.rec.body, '\n'), rec.body...)
+		a.lines++
+	} else {
+		a.active = true
+		a.rec = record{time: rec.time, stream: rec.stream, body: append([]byte(nil), rec.body...)}
+		a.lines = 1
+	}
+	a.end = end
+	a.updated = now
+
+	if a.lines >= a.maxLines {
+		done = append(done, a.flushRecord())
+	}
+	return done
+}
+
+func (a *aggregator) continues(rec record) bool {
+	if rec.stream != a.rec.stream {
+		return false
+	}
+	matched := a.pattern.Match(rec.body)
+	if a.continuation {
+		return matched
+	}
+	return !matched
+}
+
+// flush returns the pending event, if any.
+func (a *aggregator) flush() []pendingRecord {
+	if !a.active {
+		return nil
+	}
+	return []pendingRecord{a.flushRecord()}
+}
+
+func (a *aggregator) flushRecord() pendingRecord {
+	p := pendingRecord{rec: a.rec, end: a.end}
+	p.rec.joined = a.lines > 1
+	a.active = false
+	a.rec = record{}
+	a.lines = 0
+	return p
+}
+
+// expired reports whether the pending event has had no new line for the
+// timeout.
+func (a *aggregator) expired(now time.Time) bool {
+	return a.active && now.Sub(a.updated) >= a.timeout
+}
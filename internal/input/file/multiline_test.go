@@ -0,0 +1,151 @@
+package file
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kpiljoong/flox/internal/ack"
+)
+
+func newMultilineTestHandler(t *testing.T, cfg MultilineConfig) (*lineHandler, *[]map[string]interface{}) {
+	t.Helper()
+	agg, err := newAggregator(cfg)
+	if err != nil {
+		t.Fatalf("newAggregator: %v", err)
+	}
+	h, events := newTestLineHandler(0, 0)
+	h.parser = newLineParser(FormatCRI, false)
+	h.agg = agg
+	return h, events
+}
+
+func messages(events []map[string]interface{}) []interface{} {
+	msgs := make([]interface{}, len(events))
+	for i, e := range events {
+		msgs[i] = e[MessageField]
+	}
+	return msgs
+}
+
+func TestMultilineStartPattern(t *testing.T) {
+	h, events := newMultilineTestHandler(t, MultilineConfig{StartPattern: `^\d{4}-`})
+	now := time.Now()
+
+	h.line([]byte("2024-05-01T10:00:00Z stderr F 2024-05-01 panic: boom"), 10, now)
+	h.line([]byte("2024-05-01T10:00:00Z stderr F \tat main.go:12"), 20, now)
+	h.line([]byte("2024-05-01T10:00:00Z stderr F \tat main.go:5"), 30, now)
+	if len(*events) != 0 {
+		t.Fatalf("expected the trace to stay pending, got %v", messages(*events))
+	}
+	h.line([]byte("2024-05-01T10:00:01Z stderr F 2024-05-01 next"), 40, now)
+
+	if len(*events) != 1 {
+		t.Fatalf("expected 1 event, got %v", messages(*events))
+	}
+	want := "2024-05-01 panic: boom\n\tat main.go:12\n\tat main.go:5"
+	if got := (*events)[0][MessageField]; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestMultilineContinuationPattern(t *testing.T) {
+	h, events := newMultilineTestHandler(t, MultilineConfig{ContinuationPattern: `^\s`})
+	now := time.Now()
+
+	h.line([]byte("2024-05-01T10:00:00Z stdout F first"), 10, now)
+	h.line([]byte("2024-05-01T10:00:00Z stdout F   more"), 20, now)
+	h.line([]byte("2024-05-01T10:00:00Z stdout F second"), 30, now)
+	h.flushMultiline()
+
+	got := messages(*events)
+	if len(got) != 2 || got[0] != "first\n  more" || got[1] != "second" {
+		t.Errorf("unexpected messages: %q", got)
+	}
+}
+
+func TestMultilineMaxLines(t *testing.T) {
+	h, events := newMultilineTestHandler(t, MultilineConfig{ContinuationPattern: `^\s`, MaxLines: 2})
+	now := time.Now()
+
+	h.line([]byte("2024-05-01T10:00:00Z stdout F a"), 10, now)
+	h.line([]byte("2024-05-01T10:00:00Z stdout F  b"), 20, now)
+	h.line([]byte("2024-05-01T10:00:00Z stdout F  c"), 30, now)
+	h.flushMultiline()
+
+	got := messages(*events)
+	if len(got) != 2 || got[0] != "a\n b" || got[1] != " c" {
+		t.Errorf("unexpected messages: %q", got)
+	}
+}
+
+func TestMultilineTimeoutAndOffsets(t *testing.T) {
+	var saved []int64
+	h, events := newMultilineTestHandler(t, MultilineConfig{StartPattern: `^START`, Timeout: time.Second})
+	h.tracker = newOffsetTracker("test.log", 0, func(_ string, offset int64) {
+		saved = append(saved, offset)
+	})
+	inner := h.handler
+	h.handler = func(event map[string]interface{}, tok *ack.Token) {
+		inner(event, tok)
+		tok.Ack()
+	}
+	now := time.Now()
+
+	h.line([]byte("2024-05-01T10:00:00Z stdout F START"), 10, now)
+	h.line([]byte("2024-05-01T10:00:00Z stdout F detail"), 20, now)
+	h.idle(now.Add(500 * time.Millisecond))
+	if len(*events) != 0 || len(saved) != 0 {
+		t.Fatalf("expected nothing emitted before the timeout, got %v / %v", *events, saved)
+	}
+
+	h.idle(now.Add(time.Second))
+	if len(*events) != 1 || (*events)[0][MessageField] != "START\ndetail" {
+		t.Fatalf("expected the message to be flushed on timeout, got %v", messages(*events))
+	}
+	if len(saved) != 1 || saved[0] != 20 {
+		t.Errorf("expected offset 20 after the joined message, got %v", saved)
+	}
+}
+
+func TestMultilineConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     MultilineConfig
+		wantErr bool
+	}{
+		{"disabled", MultilineConfig{}, false},
+		{"start", MultilineConfig{StartPattern: `^\S`}, false},
+		{"both patterns", MultilineConfig{StartPattern: "a", ContinuationPattern: "b"}, true},
+		{"bad regexp", MultilineConfig{StartPattern: "("}, true},
+		{"negative max lines", MultilineConfig{StartPattern: "a", MaxLines: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMultilineKeepsJoinedMessageAsText(t *testing.T) {
+	h, events := newMultilineTestHandler(t, MultilineConfig{StartPattern: `^\S`})
+	h.parser = newLineParser(FormatAuto, true)
+	now := time.Now()
+
+	h.line([]byte("2024-05-01T10:00:00Z stderr F Exception in thread \"main\""), 10, now)
+	h.line([]byte("2024-05-01T10:00:00Z stderr F \tat Main.run(Main.java:3)"), 20, now)
+	h.line([]byte(`2024-05-01T10:00:01Z stdout F {"msg":"ok"}`), 30, now)
+	h.flushMultiline()
+
+	if len(*events) != 2 {
+		t.Fatalf("expected 2 events, got %v", *events)
+	}
+	want := "Exception in thread \"main\"\n\tat Main.run(Main.java:3)"
+	if got := (*events)[0]; got[MessageField] != want || got[StreamField] != "stderr" {
+		t.Errorf("expected the joined trace as text, got %v", got)
+	}
+	if got := (*events)[1]; got["msg"] != "ok" {
+		t.Errorf("expected a single JSON line to be decoded, got %v", got)
+	}
+}
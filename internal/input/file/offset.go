@@ -1,46 +1,260 @@
 package file
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 	"sync"
+	"time"
 )
 
 const stateFile = ".flox.state"
 
+const (
+	// DefaultFingerprintBytes is how much of the start of a file is hashed
+	// to tell it apart from another file that reuses its inode.
+	DefaultFingerprintBytes = 1024
+
+	// checkpointRetention is how long a checkpoint is kept after its file
+	// was last read.
+	checkpointRetention = 7 * 24 * time.Hour
+
+	checkpointVersion = 3
+)
+
 var stateLock sync.Mutex
 
-type OffsetState map[string]int64
+// Checkpoint is the position reached in a file. Files are identified by
+// device and inode, so a checkpoint follows a file that is renamed, and by a
+// hash of their first bytes, so a new file that reuses the inode of a
+// deleted one does not inherit its offset. Path is where the file was last
+// read from.
+//
+// Checkpoints are kept per source, the pipeline and input reading the file,
+// so inputs that tail the same file each keep their own position.
+type Checkpoint struct {
+	Device   uint64    `json:"dev"`
+	Inode    uint64    `json:"inode"`
+	Hash     string    `json:"hash"`
+	HashSize int       `json:"hash_size"`
+	Path     string    `json:"path"`
+	Offset   int64     `json:"offset"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// checkpointState is the state file. Before checkpoints, the state file was
+// a map from path to offset; it is migrated when it is loaded. Checkpoints of
+// version 2 were not kept per source; each is claimed by the first source
+// that finds its file.
+type checkpointState struct {
+	Version     int                    `json:"version"`
+	Checkpoints map[string]*Checkpoint `json:"checkpoints"`
+}
+
+// fileKey identifies the file open as f. Without inodes, as on Windows,
+// the path stands in for them.
+func fileKey(f *os.File, path string) (key string, dev, inode uint64, err error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	dev, inode, ok := fileID(info)
+	if !ok {
+		return "path:" + path, 0, 0, nil
+	}
+	return fmt.Sprintf("%d:%d", dev, inode), dev, inode, nil
+}
+
+// sourceKey scopes a file key to source. Keys without a source are those of
+// version 2 state files.
+func sourceKey(source, key string) string {
+	if source == "" {
+		return key
+	}
+	return source + "|" + key
+}
+
+// hashPrefix hashes up to n bytes from the start of f and returns the hash
+// and the number of bytes hashed.
+func hashPrefix(f *os.File, n int) (string, int, error) {
+	buf := make([]byte, n)
+	read, err := f.ReadAt(buf, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(buf[:read])
+	return hex.EncodeToString(sum[:]), read, nil
+}
+
+// findCheckpoint returns the checkpoint source saved for the file open as
+// f. changed is set when there is a checkpoint for its device and inode but
+// the file no longer starts with the bytes it did, because it was rewritten
+// or its inode was reused.
+func findCheckpoint(f *os.File, path, source string) (cp *Checkpoint, changed bool) {
+	key, _, _, err := fileKey(f, path)
+	if err != nil {
+		return nil, false
+	}
 
-func loadState() OffsetState {
+	stateLock.Lock()
+	checkpoints := loadCheckpointsUnlocked()
+	cp = checkpoints[sourceKey(source, key)]
+	if cp == nil {
+		if cp = checkpoints[key]; cp != nil {
+			// Claim the checkpoint of an earlier version for source.
+			delete(checkpoints, key)
+			checkpoints[sourceKey(source, key)] = cp
+			writeCheckpointsUnlocked(checkpoints)
+		}
+	}
+	stateLock.Unlock()
+	if cp == nil {
+		return nil, false
+	}
+
+	hash, size, err := hashPrefix(f, cp.HashSize)
+	if err != nil || size != cp.HashSize || hash != cp.Hash {
+		return nil, true
+	}
+	return cp, false
+}
+
+// checkpointedPaths returns the paths that files checkpointed for source,
+// including those not yet claimed by any source, were last read from.
+func checkpointedPaths(source string) map[string]bool {
 	stateLock.Lock()
 	defer stateLock.Unlock()
-	return loadStateUnlocked()
+	paths := make(map[string]bool)
+	for key, cp := range loadCheckpointsUnlocked() {
+		if scope, _, scoped := strings.Cut(key, "|"); !scoped || scope == source {
+			paths[cp.Path] = true
+		}
+	}
+	return paths
+}
+
+// checkpointSaver returns a function that saves the offset source reached in
+// the file open as f. The hash of the file's start is refreshed on each save
+// until it covers fingerprintBytes, and kept once f is closed.
+func checkpointSaver(f *os.File, source string, fingerprintBytes int) func(path string, offset int64) {
+	if fingerprintBytes <= 0 {
+		fingerprintBytes = DefaultFingerprintBytes
+	}
+	var (
+		mu       sync.Mutex
+		hash     string
+		hashSize int
+	)
+	return func(path string, offset int64) {
+		key, dev, inode, err := fileKey(f, path)
+		if err != nil {
+			log.Printf("[Tailing] Failed to identify %s, offset not saved: %v", path, err)
+			return
+		}
+
+		mu.Lock()
+		if hashSize < fingerprintBytes {
+			if h, n, err := hashPrefix(f, fingerprintBytes); err == nil {
+				hash, hashSize = h, n
+			}
+		}
+		cp := &Checkpoint{
+			Device:   dev,
+			Inode:    inode,
+			Hash:     hash,
+			HashSize: hashSize,
+			Path:     path,
+			Offset:   offset,
+			LastSeen: time.Now().UTC(),
+		}
+		mu.Unlock()
+
+		stateLock.Lock()
+		defer stateLock.Unlock()
+		checkpoints := loadCheckpointsUnlocked()
+		checkpoints[sourceKey(source, key)] = cp
+		writeCheckpointsUnlocked(checkpoints)
+	}
 }
 
-func loadStateUnlocked() OffsetState {
+func loadCheckpointsUnlocked() map[string]*Checkpoint {
 	data, err := os.ReadFile(stateFile)
 	if err != nil {
-		return make(OffsetState)
+		return make(map[string]*Checkpoint)
 	}
 
-	var state OffsetState
-	if err := json.Unmarshal(data, &state); err != nil {
-		return make(OffsetState)
+	var state checkpointState
+	if err := json.Unmarshal(data, &state); err == nil && (state.Version == checkpointVersion || state.Version == 2) {
+		if state.Checkpoints == nil {
+			state.Checkpoints = make(map[string]*Checkpoint)
+		}
+		return state.Checkpoints
 	}
 
-	return state
+	var legacy map[string]int64
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		log.Printf("[Tailing] Ignoring unreadable state file %s: %v", stateFile, err)
+		return make(map[string]*Checkpoint)
+	}
+	checkpoints := migrateOffsets(legacy)
+	writeCheckpointsUnlocked(checkpoints)
+	return checkpoints
 }
 
-func saveOffset(path string, offset int64) {
-	stateLock.Lock()
-	defer stateLock.Unlock()
+// migrateOffsets turns path-keyed offsets into checkpoints for the files
+// now at those paths. Offsets of files that no longer exist are dropped.
+func migrateOffsets(offsets map[string]int64) map[string]*Checkpoint {
+	checkpoints := make(map[string]*Checkpoint)
+	for path, offset := range offsets {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("[Tailing] Dropping saved offset of %s: %v", path, err)
+			continue
+		}
+		key, dev, inode, err := fileKey(f, path)
+		var (
+			hash string
+			size int
+		)
+		if err == nil {
+			hash, size, err = hashPrefix(f, DefaultFingerprintBytes)
+		}
+		_ = f.Close()
+		if err != nil {
+			log.Printf("[Tailing] Dropping saved offset of %s: %v", path, err)
+			continue
+		}
+		checkpoints[key] = &Checkpoint{
+			Device:   dev,
+			Inode:    inode,
+			Hash:     hash,
+			HashSize: size,
+			Path:     path,
+			Offset:   offset,
+			LastSeen: time.Now().UTC(),
+		}
+	}
+	log.Printf("[Tailing] Migrated %d of %d saved offsets to checkpoints", len(checkpoints), len(offsets))
+	return checkpoints
+}
 
-	state := loadStateUnlocked()
-	state[path] = offset
+// writeCheckpointsUnlocked saves checkpoints, dropping those that have not
+// been seen within the retention period.
+func writeCheckpointsUnlocked(checkpoints map[string]*Checkpoint) {
+	cutoff := time.Now().Add(-checkpointRetention)
+	for key, cp := range checkpoints {
+		if cp.LastSeen.Before(cutoff) {
+			delete(checkpoints, key)
+		}
+	}
 
-	data, err := json.MarshalIndent(state, "", " ")
+	data, err := json.MarshalIndent(checkpointState{Version: checkpointVersion, Checkpoints: checkpoints}, "", " ")
 	if err != nil {
 		log.Printf("Failed to marshal state: %v", err)
 		return
@@ -0,0 +1,266 @@
+package file
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// chdirTemp moves the test into a temporary directory, where the state file
+// is written.
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+}
+
+func saveCheckpoint(t *testing.T, path string, offset int64) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	checkpointSaver(f, "", 0)(path, offset)
+}
+
+// checkpointOf returns the checkpoint of the file now at path.
+func checkpointOf(t *testing.T, path string) *Checkpoint {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cp, changed := findCheckpoint(f, path, "")
+	if cp == nil {
+		t.Fatalf("expected a checkpoint for %s (changed: %v)", path, changed)
+	}
+	return cp
+}
+
+func TestCheckpointFollowsRenamedFile(t *testing.T) {
+	chdirTemp(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendLine(t, path, `{"msg":"one"}`)
+	saveCheckpoint(t, path, 14)
+
+	renamed := path + ".1"
+	if err := os.Rename(path, renamed); err != nil {
+		t.Fatal(err)
+	}
+	cp := checkpointOf(t, renamed)
+	if cp.Offset != 14 || cp.Path != path {
+		t.Errorf("expected offset 14 saved under %s, got %d under %s", path, cp.Offset, cp.Path)
+	}
+
+	// The file created in its place is new to the checkpoints.
+	appendLine(t, path, `{"msg":"two"}`)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if cp, _ := findCheckpoint(f, path, ""); cp != nil {
+		t.Errorf("expected no checkpoint for the new file, got %+v", cp)
+	}
+}
+
+func TestCheckpointRejectsRewrittenFile(t *testing.T) {
+	chdirTemp(t)
+	path := createTempLogFile(t, []string{`{"msg":"old contents"}`})
+	saveCheckpoint(t, path, 23)
+
+	// Rewrite in place, keeping the inode and the size.
+	if err := os.WriteFile(path, []byte(`{"msg":"new contents"}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if cp, changed := findCheckpoint(f, path, ""); cp != nil || !changed {
+		t.Errorf("expected the checkpoint to no longer match, got %+v (changed: %v)", cp, changed)
+	}
+}
+
+func TestCheckpointGrowsFingerprint(t *testing.T) {
+	chdirTemp(t)
+	path := createTempLogFile(t, []string{"short"})
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	save := checkpointSaver(f, "", 16)
+	save(path, 6)
+	appendLine(t, path, "a line that takes the file past the fingerprint")
+	save(path, 54)
+
+	if cp := checkpointOf(t, path); cp.HashSize != 16 || cp.Offset != 54 {
+		t.Errorf("expected a 16 byte fingerprint at offset 54, got %d bytes at %d", cp.HashSize, cp.Offset)
+	}
+}
+
+func TestCheckpointKeepsFullFingerprint(t *testing.T) {
+	chdirTemp(t)
+	path := createTempLogFile(t, []string{"0123456789abcdef"})
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	save := checkpointSaver(f, "", 16)
+	save(path, 17)
+
+	// Rewritten in place after the fingerprint was complete, the file no
+	// longer matches it even though later saves see the new contents.
+	if err := os.WriteFile(path, []byte("fedcba9876543210\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	save(path, 17)
+	if cp, changed := findCheckpoint(f, path, ""); cp != nil || !changed {
+		t.Errorf("expected the fingerprint of the original contents to be kept, got %+v (changed: %v)", cp, changed)
+	}
+}
+
+func TestCheckpointsPerSource(t *testing.T) {
+	chdirTemp(t)
+	path := createTempLogFile(t, []string{`{"msg":"one"}`, `{"msg":"two"}`})
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	checkpointSaver(f, "logs/app", 0)(path, 14)
+	checkpointSaver(f, "audit/app", 0)(path, 28)
+
+	for source, want := range map[string]int64{"logs/app": 14, "audit/app": 28} {
+		if cp, _ := findCheckpoint(f, path, source); cp == nil || cp.Offset != want {
+			t.Errorf("expected offset %d for %s, got %+v", want, source, cp)
+		}
+	}
+}
+
+func TestCheckpointClaimsUnscopedKey(t *testing.T) {
+	chdirTemp(t)
+	path := createTempLogFile(t, []string{`{"msg":"one"}`})
+	// A version 2 state file, whose keys are not scoped to a source.
+	saveCheckpoint(t, path, 14)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if cp, _ := findCheckpoint(f, path, "logs/app"); cp == nil || cp.Offset != 14 {
+		t.Fatalf("expected the unscoped checkpoint to be found, got %+v", cp)
+	}
+	if cp, _ := findCheckpoint(f, path, "audit/app"); cp != nil {
+		t.Errorf("expected the checkpoint to belong to the source that claimed it, got %+v", cp)
+	}
+	if cp, _ := findCheckpoint(f, path, "logs/app"); cp == nil || cp.Offset != 14 {
+		t.Errorf("expected the claimed checkpoint to be kept, got %+v", cp)
+	}
+}
+
+func TestMigratePathOffsets(t *testing.T) {
+	chdirTemp(t)
+	path := createTempLogFile(t, []string{`{"msg":"one"}`, `{"msg":"two"}`})
+	legacy, err := json.Marshal(map[string]int64{path: 14, "/nonexistent/app.log": 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stateFile, legacy, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if cp := checkpointOf(t, path); cp.Offset != 14 || cp.Path != path {
+		t.Errorf("expected offset 14 for %s, got %d for %s", path, cp.Offset, cp.Path)
+	}
+
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state checkpointState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != checkpointVersion || len(state.Checkpoints) != 1 {
+		t.Errorf("expected the state file rewritten with 1 checkpoint, got %s", data)
+	}
+}
+
+func TestCheckpointsExpire(t *testing.T) {
+	chdirTemp(t)
+	writeCheckpointsUnlocked(map[string]*Checkpoint{
+		"1:1": {Path: "old.log", LastSeen: time.Now().Add(-checkpointRetention - time.Hour)},
+		"1:2": {Path: "new.log", LastSeen: time.Now()},
+	})
+	if checkpoints := loadCheckpointsUnlocked(); len(checkpoints) != 1 || checkpoints["1:2"] == nil {
+		t.Errorf("expected only the recent checkpoint to be kept, got %v", checkpoints)
+	}
+}
+
+func TestTailerResumesRenamedFile(t *testing.T) {
+	chdirTemp(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendLine(t, path, `{"msg":"read"}`)
+	appendLine(t, path, `{"msg":"unread"}`)
+	saveCheckpoint(t, path, int64(len(`{"msg":"read"}`)+1))
+
+	renamed := path + ".1"
+	if err := os.Rename(path, renamed); err != nil {
+		t.Fatal(err)
+	}
+	tailer := NewTailer(renamed, "", true, "beginning")
+	tailer.readInterval = 20 * time.Millisecond
+	received := tailFile(t, tailer, renamed)
+	waitForMessages(t, received, 1, 2*time.Second)
+	time.Sleep(100 * time.Millisecond)
+
+	if got := received(); len(got) != 1 || got[0] != "unread" {
+		t.Errorf("expected only the unread line, got %v", got)
+	}
+}
+
+func TestTailerReadsRecreatedFileFromStart(t *testing.T) {
+	chdirTemp(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendLine(t, path, `{"msg":"old"}`)
+	saveCheckpoint(t, path, int64(len(`{"msg":"old"}`)+1))
+
+	// While flox is down the file is replaced by a new one, written before
+	// the old one goes so it cannot reuse its inode.
+	next := filepath.Join(t.TempDir(), "next")
+	appendLine(t, next, `{"msg":"written while down"}`)
+	if err := os.Rename(next, path); err != nil {
+		t.Fatal(err)
+	}
+
+	tailer := NewTailer(path, "", true, "latest")
+	tailer.rules = nil
+	tailer.watch = false
+	tailer.readInterval = 20 * time.Millisecond
+	received := runTailer(t, tailer)
+	waitForMessages(t, received, 1, 2*time.Second)
+
+	if got := received(); got[0] != "written while down" {
+		t.Errorf("expected the recreated file to be read from its start, got %v", got)
+	}
+}
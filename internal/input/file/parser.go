@@ -0,0 +1,191 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	FormatAuto   = "auto"
+	FormatCRI    = "cri"
+	FormatDocker = "docker"
+	FormatJSON   = "json"
+
+	// TimeField and StreamField hold the timestamp and stream (stdout or
+	// stderr) recorded by the container runtime.
+	TimeField   = "time"
+	StreamField = "stream"
+	// MessageField holds the log message when it is not decoded as JSON.
+	MessageField = "message"
+	// FileField holds the path of the file a raw text line was read from.
+	FileField = "file"
+)
+
+var errNotJSONObject = errors.New("message is not a JSON object")
+
+// record is one log line with the container runtime's framing removed.
+type record struct {
+	time    string
+	stream  string
+	partial bool
+	joined  bool // several lines joined by multiline
+	body    []byte
+}
+
+// lineParser turns raw lines into events according to the file's format.
+// With rawText set, lines that cannot be parsed are kept as text events
+// instead of being skipped.
+type lineParser struct {
+	parse      func(line []byte) (record, error)
+	decodeJSON bool
+	rawText    bool
+}
+
+// defaultParser is used when a tailer has no parser configured.
+var defaultParser = newLineParser(FormatAuto, true)
+
+func newLineParser(format string, decodeJSON bool) *lineParser {
+	p := &lineParser{decodeJSON: decodeJSON}
+	switch format {
+	case FormatCRI:
+		p.parse = parseCRI
+	case FormatDocker:
+		p.parse = parseDocker
+	case FormatJSON:
+		p.parse = parsePlain
+	default:
+		p.parse = parseAuto
+	}
+	return p
+}
+
+// event builds the event for a record. With JSON decoding on, the message
+// must be a JSON object whose fields become the event's; otherwise, and for
+// lines joined by multiline, the message is kept as text. The runtime time
+// and stream are added without overwriting fields of the same name from the
+// message.
+func (p *lineParser) event(rec record) (map[string]interface{}, error) {
+	var event map[string]interface{}
+	if p.decodeJSON && !rec.joined {
+		if err := json.Unmarshal(rec.body, &event); err != nil || event == nil {
+			return nil, errNotJSONObject
+		}
+	} else {
+		event = map[string]interface{}{MessageField: string(rec.body)}
+	}
+
+	addRuntimeFields(event, rec)
+	return event, nil
+}
+
+// raw builds a text event for a record that could not be parsed, read from
+// the file at path.
+func (p *lineParser) raw(rec record, path string) map[string]interface{} {
+	event := map[string]interface{}{
+		MessageField: string(rec.body),
+		FileField:    path,
+	}
+	addRuntimeFields(event, rec)
+	return event
+}
+
+func addRuntimeFields(event map[string]interface{}, rec record) {
+	if _, exists := event[TimeField]; !exists && rec.time != "" {
+		event[TimeField] = rec.time
+	}
+	if _, exists := event[StreamField]; !exists && rec.stream != "" {
+		event[StreamField] = rec.stream
+	}
+}
+
+// parseCRI parses the CRI log format used by containerd and CRI-O:
+//
+//	<RFC3339Nano time> <stdout|stderr> <P|F>[:flags] <message>
+//
+// P marks a partial line that continues in the next record.
+func parseCRI(line []byte) (record, error) {
+	line = trimNewline(line)
+
+	fields := bytes.SplitN(line, []byte{' '}, 4)
+	if len(fields) < 3 {
+		return record{}, fmt.Errorf("not a CRI log line")
+	}
+	ts, stream, tag := string(fields[0]), string(fields[1]), fields[2]
+	if _, err := time.Parse(time.RFC3339Nano, ts); err != nil {
+		return record{}, fmt.Errorf("invalid CRI timestamp %q", ts)
+	}
+	if stream != "stdout" && stream != "stderr" {
+		return record{}, fmt.Errorf("invalid CRI stream %q", stream)
+	}
+	if i := bytes.IndexByte(tag, ':'); i >= 0 {
+		tag = tag[:i]
+	}
+	if string(tag) != "P" && string(tag) != "F" {
+		return record{}, fmt.Errorf("invalid CRI tag %q", tag)
+	}
+
+	var body []byte
+	if len(fields) == 4 {
+		body = fields[3]
+	}
+	return record{time: ts, stream: stream, partial: string(tag) == "P", body: body}, nil
+}
+
+type dockerLine struct {
+	Log    *string `json:"log"`
+	Stream string  `json:"stream"`
+	Time   string  `json:"time"`
+}
+
+// parseDocker parses a line of Docker's json-file log driver:
+//
+//	{"log":"<message>\n","stream":"stdout","time":"<RFC3339Nano time>"}
+//
+// A message without a trailing newline was split by Docker and continues in
+// the next record.
+func parseDocker(line []byte) (record, error) {
+	var d dockerLine
+	if err := json.Unmarshal(line, &d); err != nil {
+		return record{}, fmt.Errorf("not a Docker json-file line: %w", err)
+	}
+	if d.Log == nil {
+		return record{}, fmt.Errorf("not a Docker json-file line: missing log")
+	}
+	body := []byte(*d.Log)
+	trimmed := trimNewline(body)
+	return record{
+		time:    d.Time,
+		stream:  d.Stream,
+		partial: len(trimmed) == len(body),
+		body:    trimmed,
+	}, nil
+}
+
+// parsePlain treats the whole line as the message.
+func parsePlain(line []byte) (record, error) {
+	return record{body: trimNewline(line)}, nil
+}
+
+// parseAuto recognises CRI and Docker json-file lines and falls back to
+// treating the line as a plain message.
+func parseAuto(line []byte) (record, error) {
+	trimmed := bytes.TrimLeft(line, " \t")
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		if rec, err := parseDocker(line); err == nil && rec.stream != "" && rec.time != "" {
+			return rec, nil
+		}
+		return parsePlain(line)
+	}
+	if rec, err := parseCRI(line); err == nil {
+		return rec, nil
+	}
+	return parsePlain(line)
+}
+
+func trimNewline(b []byte) []byte {
+	b = bytes.TrimSuffix(b, []byte{'\n'})
+	return bytes.TrimSuffix(b, []byte{'\r'})
+}
@@ -0,0 +1,91 @@
+package file
+
+import (
+	"testing"
+)
+
+func TestParseCRI(t *testing.T) {
+	rec, err := parseCRI([]byte("2024-05-01T10:00:00.123456789Z stderr F {\"msg\":\"a {b} c\"}\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec.time != "2024-05-01T10:00:00.123456789Z" || rec.stream != "stderr" || rec.partial {
+		t.Errorf("unexpected record metadata: %+v", rec)
+	}
+	if string(rec.body) != `{"msg":"a {b} c"}` {
+		t.Errorf("unexpected body: %q", rec.body)
+	}
+
+	rec, err = parseCRI([]byte("2024-05-01T10:00:00Z stdout P first half"))
+	if err != nil || !rec.partial || string(rec.body) != "first half" {
+		t.Errorf("expected partial record, got %+v (%v)", rec, err)
+	}
+
+	for _, line := range []string{
+		"not a cri line",
+		"2024-05-01T10:00:00Z stdin F msg",
+		"yesterday stdout F msg",
+		"2024-05-01T10:00:00Z stdout X msg",
+	} {
+		if _, err := parseCRI([]byte(line)); err == nil {
+			t.Errorf("expected error for %q", line)
+		}
+	}
+}
+
+func TestParseDocker(t *testing.T) {
+	rec, err := parseDocker([]byte(`{"log":"{\"msg\":\"hi\"}\n","stream":"stdout","time":"2024-05-01T10:00:00.5Z"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec.time != "2024-05-01T10:00:00.5Z" || rec.stream != "stdout" || rec.partial {
+		t.Errorf("unexpected record metadata: %+v", rec)
+	}
+	if string(rec.body) != `{"msg":"hi"}` {
+		t.Errorf("unexpected body: %q", rec.body)
+	}
+
+	if _, err := parseDocker([]byte(`{"msg":"plain json"}`)); err == nil {
+		t.Error("expected error for JSON without a log field")
+	}
+}
+
+func TestLineParserEvent(t *testing.T) {
+	cri := "2024-05-01T10:00:00Z stdout F "
+
+	event, err := newLineParser(FormatAuto, true).event(mustParse(t, FormatAuto, cri+`{"msg":"hi"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if event["msg"] != "hi" || event[StreamField] != "stdout" || event[TimeField] != "2024-05-01T10:00:00Z" {
+		t.Errorf("expected decoded body with runtime metadata, got %v", event)
+	}
+
+	event, _ = newLineParser(FormatAuto, true).event(mustParse(t, FormatAuto, cri+`{"time":"app time"}`))
+	if event[TimeField] != "app time" {
+		t.Errorf("expected message field to win over runtime time, got %v", event[TimeField])
+	}
+
+	event, err = newLineParser(FormatCRI, false).event(mustParse(t, FormatCRI, cri+`{"msg":"hi"}`))
+	if err != nil || event[MessageField] != `{"msg":"hi"}` {
+		t.Errorf("expected raw message with decoding off, got %v (%v)", event, err)
+	}
+
+	if _, err := newLineParser(FormatAuto, true).event(mustParse(t, FormatAuto, cri+"plain text")); err == nil {
+		t.Error("expected error for non-JSON message with decoding on")
+	}
+
+	event, _ = newLineParser(FormatAuto, true).event(mustParse(t, FormatAuto, `{"msg":"plain json"}`))
+	if event["msg"] != "plain json" {
+		t.Errorf("expected plain JSON line to be decoded, got %v", event)
+	}
+}
+
+func mustParse(t *testing.T, format, line string) record {
+	t.Helper()
+	rec, err := newLineParser(format, true).parse([]byte(line))
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", line, err)
+	}
+	return rec
+}
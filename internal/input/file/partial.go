@@ -0,0 +1,83 @@
+package file
+
+import (
+	"time"
+)
+
+const (
+	DefaultMaxLineSize    = 1 << 20
+	DefaultPartialTimeout = 5 * time.Second
+)
+
+// assembler joins the partial records a container runtime writes for a long
+// line into one record. Only one message is assembled at a time, so a
+// pending message never has a later record committed ahead of it.
+type assembler struct {
+	maxSize int
+	timeout time.Duration
+
+	active  bool
+	rec     record
+	end     int64
+	started time.Time
+}
+
+func newAssembler(maxSize int, timeout time.Duration) *assembler {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxLineSize
+	}
+	if timeout <= 0 {
+		timeout = DefaultPartialTimeout
+	}
+	return &assembler{maxSize: maxSize, timeout: timeout}
+}
+
+// pending reports whether a message is being assembled.
+func (a *assembler) pending() bool {
+	return a.active
+}
+
+// belongs reports whether rec continues the pending message.
+func (a *assembler) belongs(rec record) bool {
+	return a.active && rec.stream == a.rec.stream
+}
+
+// add appends rec, which ends at offset end, to the pending message. If the
+// message would grow past the maximum size, the part assembled so far is
+// returned to be emitted on its own and rec starts a new message.
+func (a *assembler) add(rec record, end int64, now time.Time) (record, int64, bool) {
+	var (
+		flushed    record
+		flushedEnd int64
+		ok         bool
+	)
+	if a.active && len(a.rec.body)+len(rec.body) > a.maxSize {
+		flushed, flushedEnd, ok = a.flush()
+	}
+
+	if !a.active {
+		a.active = true
+		a.rec = record{time: rec.time, stream: rec.stream}
+		a.started = now
+	}
+	a.rec.body = append(a.rec.body, rec.body...)
+	a.end = end
+	return flushed, flushedEnd, ok
+}
+
+// flush returns the pending message, complete or not, and resets.
+func (a *assembler) flush() (record, int64, bool) {
+	if !a.active {
+		return record{}, 0, false
+	}
+	rec, end := a.rec, a.end
+	a.active = false
+	a.rec = record{}
+	return rec, end, true
+}
+
+// expired reports whether the pending message has waited too long for its
+// final chunk.
+func (a *assembler) expired(now time.Time) bool {
+	return a.active && now.Sub(a.started) >= a.timeout
+}
@@ -0,0 +1,175 @@
+package file
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MatchRules lists patterns for the namespace, pod name and container name
+// of a pod log file. A pattern is a glob such as "kube-*", or a regular
+// expression between slashes such as "/^team-(a|b)$/".
+type MatchRules struct {
+	Namespaces []string `mapstructure:"namespaces"`
+	Pods       []string `mapstructure:"pods"`
+	Containers []string `mapstructure:"containers"`
+}
+
+// DefaultExclude skips the pods of common infrastructure and of the logging
+// stack itself. It applies unless exclude rules are configured.
+var DefaultExclude = MatchRules{
+	Pods: []string{
+		"istio-proxy*",
+		"coredns*",
+		"etcd*",
+		"kube-proxy*",
+		"metrics-server*",
+		"loki*",
+		"grafana*",
+		"flox*",
+	},
+}
+
+func (r MatchRules) Validate() error {
+	_, err := r.compile()
+	return err
+}
+
+type pattern struct {
+	text string
+	glob string
+	re   *regexp.Regexp
+}
+
+func compilePattern(text string) (pattern, error) {
+	if len(text) >= 2 && strings.HasPrefix(text, "/") && strings.HasSuffix(text, "/") {
+		re, err := regexp.Compile(text[1 : len(text)-1])
+		if err != nil {
+			return pattern{}, fmt.Errorf("invalid pattern %s: %w", text, err)
+		}
+		return pattern{text: text, re: re}, nil
+	}
+	if _, err := path.Match(text, ""); err != nil {
+		return pattern{}, fmt.Errorf("invalid pattern %q: %w", text, err)
+	}
+	return pattern{text: text, glob: text}, nil
+}
+
+func (p pattern) match(s string) bool {
+	if p.re != nil {
+		return p.re.MatchString(s)
+	}
+	ok, _ := path.Match(p.glob, s)
+	return ok
+}
+
+// patterns are the compiled patterns of one kind of name.
+type patterns []pattern
+
+// match returns the first pattern that matches s.
+func (ps patterns) match(s string) (pattern, bool) {
+	for _, p := range ps {
+		if p.match(s) {
+			return p, true
+		}
+	}
+	return pattern{}, false
+}
+
+type compiledRules struct {
+	namespaces, pods, containers patterns
+}
+
+func (r MatchRules) compile() (compiledRules, error) {
+	var c compiledRules
+	lists := []struct {
+		src []string
+		dst *patterns
+	}{
+		{r.Namespaces, &c.namespaces},
+		{r.Pods, &c.pods},
+		{r.Containers, &c.containers},
+	}
+	for _, l := range lists {
+		for _, text := range l.src {
+			p, err := compilePattern(text)
+			if err != nil {
+				return compiledRules{}, err
+			}
+			*l.dst = append(*l.dst, p)
+		}
+	}
+	return c, nil
+}
+
+// fileRules decide which files a tailer follows. For each of namespace, pod
+// and container, a file must match one of the include patterns, if there
+// are any, and none of the exclude patterns.
+type fileRules struct {
+	include compiledRules
+	exclude compiledRules
+}
+
+// skipReason says why a file is not tailed. label is a short form for
+// metrics.
+type skipReason struct {
+	label  string
+	detail string
+}
+
+// newFileRules compiles the rules. exclude defaults to DefaultExclude when
+// nil. A namespace, as set with the input's namespace option, is added to
+// the namespaces to include.
+func newFileRules(include MatchRules, exclude *MatchRules, namespace string) (*fileRules, error) {
+	if namespace != "" {
+		include.Namespaces = append(append([]string(nil), include.Namespaces...), namespace)
+	}
+	if exclude == nil {
+		exclude = &DefaultExclude
+	}
+	in, err := include.compile()
+	if err != nil {
+		return nil, fmt.Errorf("include: %w", err)
+	}
+	ex, err := exclude.compile()
+	if err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+	return &fileRules{include: in, exclude: ex}, nil
+}
+
+// check reports why filePath should not be tailed, if it should not. Files
+// outside the <namespace>_<pod>_<uid>/<container>/ layout of pod logs are
+// never tailed.
+func (r *fileRules) check(filePath string) (skipReason, bool) {
+	if r == nil {
+		return skipReason{}, false
+	}
+	pod, ok := parsePodLogPath(filePath)
+	if !ok {
+		return skipReason{"not_pod_log", "not a pod log file"}, true
+	}
+
+	names := []struct {
+		kind    string
+		value   string
+		include patterns
+		exclude patterns
+	}{
+		{"namespace", pod.namespace, r.include.namespaces, r.exclude.namespaces},
+		{"pod", pod.podName, r.include.pods, r.exclude.pods},
+		{"container", pod.container, r.include.containers, r.exclude.containers},
+	}
+	for _, n := range names {
+		if len(n.include) > 0 {
+			if _, ok := n.include.match(n.value); !ok {
+				return skipReason{n.kind + "_not_included", fmt.Sprintf("%s %s matches no include pattern", n.kind, n.value)}, true
+			}
+		}
+		if p, ok := n.exclude.match(n.value); ok {
+			return skipReason{n.kind + "_excluded", fmt.Sprintf("%s %s matches exclude pattern %s", n.kind, n.value, p.text)}, true
+		}
+	}
+	return skipReason{}, false
+}
@@ -0,0 +1,102 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/kpiljoong/flox/internal/ack"
+	"github.com/kpiljoong/flox/internal/input"
+	"github.com/kpiljoong/flox/internal/metrics"
+	"github.com/kpiljoong/flox/internal/plugin"
+)
+
+func podLogPath(namespace, pod, container string) string {
+	return filepath.Join("/var/log/pods", namespace+"_"+pod+"_uid", container, "0.log")
+}
+
+func TestFileRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		include   MatchRules
+		exclude   *MatchRules
+		namespace string
+		path      string
+		want      string // skip reason label, empty if tailed
+	}{
+		{"default tails app pods", MatchRules{}, nil, "", podLogPath("shop", "cart-1", "app"), ""},
+		{"default skips infra pods", MatchRules{}, nil, "", podLogPath("monitoring", "grafana-0", "grafana"), "pod_excluded"},
+		{"configured exclude replaces defaults", MatchRules{}, &MatchRules{Namespaces: []string{"kube-*"}}, "", podLogPath("monitoring", "grafana-0", "grafana"), ""},
+		{"configured exclude", MatchRules{}, &MatchRules{Namespaces: []string{"kube-*"}}, "", podLogPath("kube-system", "dns", "dns"), "namespace_excluded"},
+		{"namespace include glob", MatchRules{Namespaces: []string{"shop", "pay-*"}}, nil, "", podLogPath("pay-eu", "api", "app"), ""},
+		{"namespace not included", MatchRules{Namespaces: []string{"shop", "pay-*"}}, nil, "", podLogPath("other", "api", "app"), "namespace_not_included"},
+		{"namespace option", MatchRules{Namespaces: []string{"shop"}}, nil, "pay", podLogPath("pay", "api", "app"), ""},
+		{"container regexp", MatchRules{Containers: []string{"/^(app|web)$/"}}, nil, "", podLogPath("shop", "api", "istio"), "container_not_included"},
+		{"container regexp exclude", MatchRules{}, &MatchRules{Containers: []string{"/-sidecar$/"}}, "", podLogPath("shop", "api", "log-sidecar"), "container_excluded"},
+		{"plain file without include", MatchRules{}, nil, "", "/var/log/app/server.log", "not_pod_log"},
+		{"plain file with include", MatchRules{Pods: []string{"api"}}, nil, "", "/var/log/app/server.log", "not_pod_log"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := newFileRules(tt.include, tt.exclude, tt.namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reason, skip := rules.check(tt.path)
+			if skip != (tt.want != "") || reason.label != tt.want {
+				t.Errorf("check(%s) = %q (%v), want %q", tt.path, reason.label, reason.detail, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileRulesInvalidPattern(t *testing.T) {
+	if _, err := newFileRules(MatchRules{Pods: []string{"/(/"}}, nil, ""); err == nil {
+		t.Error("expected an invalid regexp to be rejected")
+	}
+	if _, err := newFileRules(MatchRules{}, &MatchRules{Namespaces: []string{"[a-"}}, ""); err == nil {
+		t.Error("expected an invalid glob to be rejected")
+	}
+}
+
+func TestConfigEmptyExcludeDisablesDefaults(t *testing.T) {
+	var cfg Config
+	err := plugin.Decode(map[string]interface{}{"path": "/var/log/pods/*/*/*.log", "exclude": map[string]interface{}{}}, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Exclude == nil {
+		t.Fatal("expected an empty exclude section to replace the defaults")
+	}
+	if err := input.Validate("file", map[string]interface{}{"path": "x", "include": map[string]interface{}{"pods": []string{"/(/"}}}); err == nil {
+		t.Error("expected an invalid include pattern to fail validation")
+	}
+}
+
+func TestTailerReportsSkippedFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{"shop_cart_uid/app/0.log", "monitoring_grafana-0_uid/grafana/0.log"} {
+		path := filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	glob := filepath.Join(root, "*", "*", "*.log")
+	tailer := NewTailer(glob, "", false, "beginning")
+	tailer.pipeline = "logs"
+	defer tailer.Shutdown()
+	tailer.scanForNewFiles(func(map[string]interface{}, *ack.Token) {})
+
+	if len(tailer.skipped) != 1 {
+		t.Fatalf("expected 1 skipped file, got %v", tailer.skipped)
+	}
+	if got := testutil.ToFloat64(metrics.FilesSkipped.WithLabelValues("logs", glob, "pod_excluded")); got != 1 {
+		t.Errorf("expected 1 file reported as pod_excluded, got %v", got)
+	}
+}
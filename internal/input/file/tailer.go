@@ -2,144 +2,425 @@ package file
 
 import (
 	"bufio"
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
+
+	"github.com/kpiljoong/flox/internal/kubernetes"
+	"github.com/kpiljoong/flox/internal/metrics"
 )
 
 var ErrNoSavedOffset = fmt.Errorf("no saved offset")
 
-var knownInfraContainers = []string{
-	"istio-proxy",
-	"coredns",
-	"etcd",
-	"kube-proxy",
-	"metrics-server",
-	"loki",
-	"grafana",
-	"flox",
-}
+// DefaultRotateWait is how long a rotated file is still read after its end
+// was reached, for writers that have not reopened the path yet.
+const DefaultRotateWait = 5 * time.Second
 
 type Tailer struct {
+	// pipeline names the pipeline fed, for metric labels.
+	pipeline    string
 	path        string
-	namespace   string
 	trackOffset bool
 	startFrom   string
+	parser      *lineParser
 	files       map[string]*os.File
 	lock        sync.Mutex
+	wg          sync.WaitGroup
 	ctx         context.Context
 	cancel      context.CancelFunc
+
+	// maxLineSize and partialTimeout bound the reassembly of partial lines;
+	// zero means the defaults.
+	maxLineSize    int
+	partialTimeout time.Duration
+	// multiline joins lines into messages before they are decoded; each
+	// file is aggregated on its own.
+	multiline MultilineConfig
+	// kubernetes controls the metadata taken from pod log paths, and pods,
+	// if set, the metadata taken from the Kubernetes API.
+	kubernetes KubernetesConfig
+	pods       *kubernetes.PodCache
+
+	// rules select the files to tail; skipped holds the matching files they
+	// rejected at the last scan.
+	rules   *fileRules
+	skipped map[string]skipReason
+	reasons map[string]bool
+
+	// draining holds rotated files that are read to their end, by the path
+	// they were tailed under; rotated holds paths of rotated files, such as
+	// app.log.1, that have been read under their original path, with the
+	// file found there.
+	draining   map[*os.File]string
+	rotated    map[string]os.FileInfo
+	rotateWait time.Duration
+
+	// retryDelay is how long to wait before a file is read again after a
+	// failed delivery; zero means DefaultRetryDelay.
+	retryDelay time.Duration
+
+	// id is the input's id; with pipeline it scopes the checkpoints. Paths
+	// in checkpointed were checkpointed when the tailer started, so a file
+	// without a checkpoint found at one of them was recreated.
+	id           string
+	checkpointed map[string]bool
+
+	// fingerprintBytes is how much of the start of a file identifies it in
+	// checkpoints; zero means DefaultFingerprintBytes.
+	fingerprintBytes int
+
+	// watch turns on fsnotify; watcher is nil when it is off or failed.
+	// scanInterval and readInterval are how often to look for new files and
+	// for new lines without it, or as a safety net with it.
+	watch        bool
+	watcher      *watcher
+	scanInterval time.Duration
+	readInterval time.Duration
 }
 
 func NewTailer(path string, namespace string, track bool, startFrom string) *Tailer {
 	ctx, cancel := context.WithCancel(context.Background())
+	// The default rules always compile.
+	rules, _ := newFileRules(MatchRules{}, nil, namespace)
 	return &Tailer{
 		path:        path,
-		namespace:   namespace,
 		trackOffset: track,
 		startFrom:   startFrom,
 		files:       make(map[string]*os.File),
 		ctx:         ctx,
 		cancel:      cancel,
+		rules:       rules,
+		watch:       true,
 	}
 }
 
-func stripKubernetesPrefix(line []byte) []byte {
-	idx := bytes.IndexByte(line, '{')
-	if idx != -1 {
-		return line[idx:]
-	}
-	return line
+func (t *Tailer) openFile(filePath string, handler HandlerFunc) {
+	t.tailFile(filePath, handler, false)
 }
 
-func isRelevantLog(filePath string, allowedNamespace string) bool {
-	dir := filepath.Dir(filePath)
-	podDir := filepath.Dir(dir)
-	podNameFull := filepath.Base(podDir)
+// tailFile follows filePath until the tailer shuts down or the file is
+// rotated away and drained. A file that replaced a rotated one is read from
+// its start.
+func (t *Tailer) tailFile(filePath string, handler HandlerFunc, replacement bool) {
+	log.Printf("[Tailing] Attempting to open: %s", filePath)
 
-	parts := strings.SplitN(podNameFull, "_", 3)
-	if len(parts) < 3 {
-		return false
+	f, err := os.Open(filePath)
+	if err != nil {
+		log.Printf("[Tailing] Failed to open file %s: %v", filePath, err)
+		t.unregisterFile(filePath, nil)
+		return
 	}
 
-	namespace, podName := parts[0], parts[1]
-	if allowedNamespace != "" && namespace != allowedNamespace {
-		return false
-	}
+	t.registerFile(filePath, f)
+	defer t.unregisterFile(filePath, f)
 
-	for _, infra := range knownInfraContainers {
-		if strings.HasPrefix(podName, infra) {
-			return false
-		}
+	if !replacement {
+		t.handleSeek(filePath, f)
 	}
 
-	return true
-}
+	// Track the position ourselves: the bufio reader reads ahead, so the
+	// file's own offset is past what has actually been handled.
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		log.Printf("[Tailing] Failed to get current offset for %s: %v", filePath, err)
+		return
+	}
 
-func (t *Tailer) openFile(filePath string, handler HandlerFunc) {
-	log.Printf("[Tailing] Attempting to open: %s", filePath)
+	var (
+		tracker *offsetTracker
+		save    func(path string, offset int64)
+	)
+	newTracker := func(from int64) *offsetTracker {
+		tr := newOffsetTracker(filePath, from, save)
+		if t.retryDelay > 0 {
+			tr.retryDelay = t.retryDelay
+		}
+		return tr
+	}
+	if t.trackOffset {
+		// Checkpoint right away, so the file keeps its position if it is
+		// renamed before any of its lines are acknowledged.
+		save = checkpointSaver(f, t.source(), t.fingerprintBytes)
+		save(filePath, offset)
+		tracker = newTracker(offset)
+	}
 
-	f, err := os.Open(filePath)
+	parser := t.parser
+	if parser == nil {
+		parser = defaultParser
+	}
+	agg, err := newAggregator(t.multiline)
 	if err != nil {
-		log.Printf("[Tailing] Failed to open file %s: %v", filePath, err)
+		log.Printf("[Tailing] Invalid multiline settings for %s: %v", filePath, err)
 		return
 	}
+	lines := &lineHandler{
+		pipeline: t.pipeline,
+		input:    t.path,
+		path:     filePath,
+		parser:   parser,
+		asm:      newAssembler(t.maxLineSize, t.partialTimeout),
+		agg:      agg,
+		meta:     newPodMetadata(filePath, t.kubernetes, t.pods),
+		tracker:  tracker,
+		handler:  handler,
+	}
 
-	t.registerFile(filePath, f)
-	defer t.unregisterFile(filePath)
-
-	t.handleSeek(filePath, f)
+	var changed chan struct{}
+	if t.watcher != nil {
+		changed = t.watcher.subscribe(filePath)
+		defer t.watcher.unsubscribe(filePath, changed)
+	}
 
 	log.Printf("[Tailing] Start tailing: %s", filePath)
 
 	reader := bufio.NewReader(f)
-	var warned bool
+	var (
+		partial   []byte
+		rotatedAt time.Time
+		switched  bool
+	)
 
 	for {
+		if t.ctx.Err() != nil {
+			return
+		}
+
+		if tracker != nil {
+			if from, ok := tracker.retry(); ok {
+				log.Printf("[Tailing] Reading %s again from offset %d after a failed delivery", filePath, from)
+				// Acks for lines read before must not move the offset, and
+				// messages still being assembled are read again.
+				tracker.close()
+				tracker = newTracker(from)
+				lines.tracker = tracker
+				lines.reset()
+				save(filePath, from)
+				if _, err := f.Seek(from, io.SeekStart); err != nil {
+					log.Printf("[Tailing] Failed to seek to offset %d of %s: %v", from, filePath, err)
+					return
+				}
+				offset, partial = from, nil
+				reader.Reset(f)
+				continue
+			}
+			if tracker.blocked() {
+				// Apply backpressure until lines are acknowledged or the
+				// failed ones are due to be retried.
+				if !t.waitForChange(nil, true) {
+					return
+				}
+				continue
+			}
+		}
+
 		line, err := reader.ReadBytes('\n')
 		if err != nil {
 			if err == io.EOF {
-				time.Sleep(1 * time.Second)
+				// Keep an incomplete trailing line until the rest is written.
+				partial = append(partial, line...)
+
+				if !rotatedAt.IsZero() {
+					// The rotated file has been read to its end, so its
+					// lines come before those of the new file. Writers that
+					// still hold it open get the grace period to finish.
+					if !switched {
+						t.replaceRotated(filePath, f, handler)
+						switched = true
+					}
+					if time.Since(rotatedAt) >= t.rotateGrace() {
+						lines.flush("file rotated")
+						log.Printf("[Tailing] Finished draining rotated file %s", filePath)
+						return
+					}
+					if !t.waitForChange(nil, true) {
+						return
+					}
+					lines.idle(time.Now())
+					reader.Reset(f)
+					continue
+				}
 
-				if t.isFileRotated(filePath, f) {
-					log.Printf("[Tailing] File rotated: reopening %s", filePath)
-					t.unregisterFile(filePath)
-					go t.openFile(filePath, handler)
+				if !t.waitForChange(changed, lines.pending()) {
 					return
 				}
-				reader = bufio.NewReader(f)
+				lines.idle(time.Now())
+
+				if t.isFileTruncated(f, offset+int64(len(partial))) {
+					log.Printf("[Tailing] File truncated: restarting %s from the beginning", filePath)
+					metrics.FilesTruncated.WithLabelValues(t.pipeline, t.path).Inc()
+					lines.flush("file truncated")
+					if tracker != nil {
+						// Acks for lines of the old contents must not move
+						// the new offset.
+						tracker.close()
+						tracker = newTracker(0)
+						lines.tracker = tracker
+						save(filePath, 0)
+					}
+					if _, err := f.Seek(0, io.SeekStart); err != nil {
+						log.Printf("[Tailing] Failed to seek to start of %s: %v", filePath, err)
+						return
+					}
+					offset, partial = 0, nil
+					reader.Reset(f)
+					continue
+				}
+
+				if t.isFileRotated(filePath, f) {
+					log.Printf("[Tailing] File rotated: draining %s before reopening it", filePath)
+					rotatedAt = time.Now()
+				}
+				reader.Reset(f)
 				continue
 			}
 
 			log.Printf("[Taililng] Error reading file %s: %v", filePath, err)
 			break
 		}
+		if len(partial) > 0 {
+			line = append(partial, line...)
+			partial = nil
+		}
+		offset += int64(len(line))
+		lines.line(line, offset, time.Now())
+	}
+}
 
-		cleanLine := stripKubernetesPrefix(line)
+// waitForChange waits at EOF until the file may have more data. Without a
+// watcher that is after the read interval. With one, it is when the file
+// changes, and the read interval only applies while a partial or multiline
+// message waits for its timeout; otherwise the scan interval serves as a
+// safety net. It returns false when the tailer is shutting down.
+func (t *Tailer) waitForChange(changed chan struct{}, pending bool) bool {
+	wait := t.readInterval
+	if wait <= 0 {
+		wait = DefaultReadInterval
+	}
+	if changed != nil && !pending {
+		wait = t.scanInterval
+		if wait <= 0 {
+			wait = DefaultScanInterval
+		}
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
 
-		var event map[string]interface{}
-		if err := json.Unmarshal(cleanLine, &event); err != nil {
-			if !warned {
-				log.Printf("[Tailing] Skipping invalid JSON in %s: %s", filePath, string(line))
-				warned = true
-			}
-			continue
+	select {
+	case <-t.ctx.Done():
+		return false
+	case <-changed:
+	case <-timer.C:
+	}
+	return true
+}
+
+func (t *Tailer) rotateGrace() time.Duration {
+	if t.rotateWait <= 0 {
+		return DefaultRotateWait
+	}
+	return t.rotateWait
+}
+
+// startTailing starts following filePath. The path is reserved right away,
+// so that a scan before the file is open does not start it again. The caller
+// holds lock.
+func (t *Tailer) startTailing(filePath string, handler HandlerFunc, replacement bool) {
+	t.files[filePath] = nil
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		t.tailFile(filePath, handler, replacement)
+	}()
+}
+
+// replaceRotated hands filePath over to the file that replaced f, which
+// keeps being drained, unless the new file is itself one that is already
+// being read.
+func (t *Tailer) replaceRotated(filePath string, f *os.File, handler HandlerFunc) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.files[filePath] == f {
+		delete(t.files, filePath)
+	}
+	if t.draining == nil {
+		t.draining = make(map[*os.File]string)
+	}
+	t.draining[f] = filePath
+
+	if info, err := os.Stat(filePath); err == nil && t.isOpen(info) {
+		t.markRotated(filePath, info)
+		return
+	}
+	log.Printf("[Tailing] Reopening rotated file: %s", filePath)
+	t.startTailing(filePath, handler, true)
+}
+
+// isOpen reports whether the file described by info is open, being tailed
+// or drained, under any path. The caller holds lock.
+func (t *Tailer) isOpen(info os.FileInfo) bool {
+	same := func(f *os.File) bool {
+		open, err := f.Stat()
+		return err == nil && os.SameFile(open, info)
+	}
+	for _, f := range t.files {
+		if f != nil && same(f) {
+			return true
 		}
+	}
+	for f := range t.draining {
+		if same(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// markRotated records that filePath holds the file described by info, which
+// was rotated away from a tailed path, such as app.log.1 after app.log was
+// renamed, and has been read under that path. The caller holds lock.
+func (t *Tailer) markRotated(filePath string, info os.FileInfo) {
+	if t.rotated == nil {
+		t.rotated = make(map[string]os.FileInfo)
+	}
+	if _, ok := t.rotated[filePath]; !ok {
+		log.Printf("[Tailing] %s is a rotated file that is already read, not tailing it again", filePath)
+	}
+	t.rotated[filePath] = info
+}
 
-		warned = false
-		handler(event)
+// isRotated reports whether filePath still holds the rotated file recorded
+// for it, and forgets the path once the file is gone or replaced. The caller
+// holds lock.
+func (t *Tailer) isRotated(filePath string) bool {
+	info, ok := t.rotated[filePath]
+	if !ok {
+		return false
+	}
+	if current, err := os.Stat(filePath); err == nil && os.SameFile(current, info) {
+		return true
+	}
+	delete(t.rotated, filePath)
+	return false
+}
 
-		if t.trackOffset {
-			t.saveCurrentOffset(filePath, f)
+// forgetRotated drops the rotated paths that no longer match the pattern.
+// The caller holds lock.
+func (t *Tailer) forgetRotated(matches []string) {
+	matched := make(map[string]bool, len(matches))
+	for _, path := range matches {
+		matched[path] = true
+	}
+	for path := range t.rotated {
+		if !matched[path] {
+			delete(t.rotated, path)
 		}
 	}
 }
@@ -150,22 +431,78 @@ func (t *Tailer) registerFile(filePath string, f *os.File) {
 	t.files[filePath] = f
 }
 
-func (t *Tailer) unregisterFile(filePath string) {
+// unregisterFile releases filePath and closes f, which is nil if the file
+// could not be opened. A file rotated away from filePath is marked as read
+// under any sibling path it was renamed to.
+func (t *Tailer) unregisterFile(filePath string, f *os.File) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
-	if f, ok := t.files[filePath]; ok && f != nil {
-		defer func() {
-			if err := f.Close(); err != nil {
-				log.Printf("[Tailing] Failed to close file %s: %v", filePath, err)
-			}
-		}()
+	if current, ok := t.files[filePath]; ok && current == f {
+		delete(t.files, filePath)
+	}
+	if f == nil {
+		return
+	}
+	if _, ok := t.draining[f]; ok {
+		delete(t.draining, f)
+		t.markRotatedCopies(f)
+	}
+	if err := f.Close(); err != nil {
+		log.Printf("[Tailing] Failed to close file %s: %v", filePath, err)
 	}
-	delete(t.files, filePath)
+}
+
+// markRotatedCopies marks the paths matching the pattern that hold f. The
+// caller holds lock.
+func (t *Tailer) markRotatedCopies(f *os.File) {
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+	matches, _ := filepath.Glob(t.path)
+	for _, path := range matches {
+		if _, tailing := t.files[path]; tailing || t.isRotated(path) {
+			continue
+		}
+		if other, err := os.Stat(path); err == nil && os.SameFile(info, other) {
+			t.markRotated(path, other)
+		}
+	}
+}
+
+// source names the pipeline and input the tailer reads for, which its
+// checkpoints are kept under.
+func (t *Tailer) source() string {
+	if t.pipeline == "" && t.id == "" {
+		return ""
+	}
+	return t.pipeline + "/" + t.id
 }
 
 func (t *Tailer) seekFromSavedOffset(filePath string, f *os.File) error {
-	state := loadState()
-	if offset, ok := state[filePath]; ok {
+	cp, changed := findCheckpoint(f, filePath, t.source())
+	if changed {
+		// The same inode holds different contents: the file was rewritten,
+		// or deleted and its inode reused by a new file.
+		log.Printf("[Tailing] %s no longer matches its checkpoint: starting from the beginning", filePath)
+		return nil
+	}
+	if cp == nil && t.checkpointed[filePath] {
+		// Another file was read from this path: it was deleted or rotated
+		// away and the file there now was created in its place.
+		log.Printf("[Tailing] %s was recreated since it was checkpointed: starting from the beginning", filePath)
+		return nil
+	}
+	if cp != nil {
+		offset := cp.Offset
+		if cp.Path != filePath {
+			log.Printf("[Tailing] %s was renamed from %s", filePath, cp.Path)
+		}
+		if t.isFileTruncated(f, offset) {
+			log.Printf("[Tailing] Saved offset %d is past the end of %s, which was truncated: starting from the beginning", offset, filePath)
+			metrics.FilesTruncated.WithLabelValues(t.pipeline, t.path).Inc()
+			offset = 0
+		}
 		log.Printf("[Tailing] Resuming %s from offset: %d", filePath, offset)
 		if _, err := f.Seek(offset, io.SeekStart); err != nil {
 			log.Printf("[Tailing] Seek failed for %s: %v", filePath, err)
@@ -177,13 +514,14 @@ func (t *Tailer) seekFromSavedOffset(filePath string, f *os.File) error {
 	return ErrNoSavedOffset // fmt.Errorf("no saved offset for %s", filePath)
 }
 
-func (t *Tailer) saveCurrentOffset(filePath string, f *os.File) {
-	offset, err := f.Seek(0, io.SeekCurrent)
+// isFileTruncated reports whether f has shrunk below position, as when it
+// is truncated in place by copytruncate rotation.
+func (t *Tailer) isFileTruncated(f *os.File, position int64) bool {
+	info, err := f.Stat()
 	if err != nil {
-		log.Printf("[Tailing] Failed to get current offset for %s: %v", filePath, err)
-		return
+		return false
 	}
-	saveOffset(filePath, offset)
+	return info.Size() < position
 }
 
 func (t *Tailer) isFileRotated(filePath string, f *os.File) bool {
@@ -196,7 +534,10 @@ func (t *Tailer) isFileRotated(filePath string, f *os.File) bool {
 }
 
 func (t *Tailer) scanForNewFiles(handler HandlerFunc) {
-	// log.Printf("[Tailing] Watching for new files matching: %s", t.path)
+	// Watch before globbing so that nothing created in between is missed.
+	if t.watcher != nil {
+		t.watcher.watchPattern(t.path)
+	}
 
 	matches, err := filepath.Glob(t.path)
 	if err != nil {
@@ -208,26 +549,56 @@ func (t *Tailer) scanForNewFiles(handler HandlerFunc) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
-	if len(matches) > len(t.files) {
+	t.forgetRotated(matches)
+	if len(matches) > len(t.files)+len(t.skipped)+len(t.rotated) {
 		log.Printf("[Tailing] New files detected: %d files now being tailed", len(matches))
 	}
 
+	skipped := make(map[string]skipReason)
 	for _, filePath := range matches {
 		if _, alreadyTailing := t.files[filePath]; alreadyTailing {
 			// log.Printf("[Tailing] Already tailing: %s", filePath)
 			continue
 		}
 
-		if !isRelevantLog(filePath, t.namespace) {
-			log.Printf("[Tailling] Skipping irrelevant log file: %s", filePath)
-			t.files[filePath] = nil // mark as ignored
+		if t.isRotated(filePath) {
+			continue
+		}
+
+		if reason, skip := t.rules.check(filePath); skip {
+			if _, seen := t.skipped[filePath]; !seen {
+				log.Printf("[Tailing] Skipping %s: %s", filePath, reason.detail)
+			}
+			skipped[filePath] = reason
+			continue
+		}
+		if info, err := os.Stat(filePath); err == nil && t.isOpen(info) {
+			t.markRotated(filePath, info)
 			continue
 		}
 		log.Printf("[Tailing] New file detected: %s", filePath)
-		// t.files[filePath] = nil
-		go t.openFile(filePath, handler)
+		t.startTailing(filePath, handler, false)
+	}
+	t.skipped = skipped
+	t.reportSkipped()
+}
+
+// reportSkipped publishes the number of skipped files by reason. The caller
+// holds lock.
+func (t *Tailer) reportSkipped() {
+	counts := make(map[string]int)
+	for _, reason := range t.skipped {
+		counts[reason.label]++
+	}
+	if t.reasons == nil {
+		t.reasons = make(map[string]bool)
+	}
+	for label := range counts {
+		t.reasons[label] = true
+	}
+	for label := range t.reasons {
+		metrics.FilesSkipped.WithLabelValues(t.pipeline, t.path, label).Set(float64(counts[label]))
 	}
-	// t.lock.Unlock()
 }
 
 func (t *Tailer) handleSeek(filePath string, f *os.File) {
@@ -263,21 +634,51 @@ func (t *Tailer) handleSeek(filePath string, f *os.File) {
 }
 
 func (t *Tailer) Run(ctx context.Context, handler HandlerFunc) {
-	ticker := time.NewTicker(10 * time.Second)
+	interval := t.scanInterval
+	if interval <= 0 {
+		interval = DefaultScanInterval
+	}
+
+	var created chan struct{}
+	if t.watch {
+		w, err := newWatcher()
+		if err != nil {
+			log.Printf("[Tailer] File watching unavailable, polling every %s: %v", interval, err)
+		} else {
+			t.watcher = w
+			created = w.scan
+			go w.run(t.ctx)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	if t.trackOffset {
+		// Note the checkpointed paths before the first file is opened and
+		// its checkpoint moves to a new path.
+		t.checkpointed = checkpointedPaths(t.source())
+	}
+	t.scanForNewFiles(handler)
 	for {
 		select {
-		case <-t.ctx.Done():
+		case <-ctx.Done():
 			log.Println("[Tailer] Shutdown requested. Exiting Run loop.")
 			t.Shutdown()
+			t.wg.Wait()
 			return
 
+		case <-t.ctx.Done():
+			log.Println("[Tailer] Shutdown requested. Exiting Run loop.")
+			t.wg.Wait()
+			return
+
+		case <-created:
+			t.scanForNewFiles(handler)
+
 		case <-ticker.C:
 			t.scanForNewFiles(handler)
 		}
-
-		// time.Sleep(10 * time.Second)
 	}
 }
 
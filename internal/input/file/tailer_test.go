@@ -7,6 +7,11 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/kpiljoong/flox/internal/ack"
+	"github.com/kpiljoong/flox/internal/metrics"
 )
 
 func createTempLogFile(t *testing.T, lines []string) string {
@@ -51,7 +56,7 @@ func TestTailerReadsNewLines(t *testing.T) {
 	// tailer := file.NewTailer(tmpFile, "default", false, "beginning")
 
 	var events []map[string]interface{}
-	handler := func(event map[string]interface{}) {
+	handler := func(event map[string]interface{}, _ *ack.Token) {
 		events = append(events, event)
 	}
 
@@ -88,7 +93,7 @@ func TestTailerIgnoresInvalidJSON(t *testing.T) {
 	}
 
 	var events []map[string]interface{}
-	handler := func(event map[string]interface{}) {
+	handler := func(event map[string]interface{}, _ *ack.Token) {
 		events = append(events, event)
 	}
 
@@ -104,3 +109,198 @@ func TestTailerIgnoresInvalidJSON(t *testing.T) {
 		t.Errorf("unexpected event content: got %s", events[0]["msg"])
 	}
 }
+
+// tailFile runs openFile on path until the test ends and returns the
+// messages received so far.
+func tailFile(t *testing.T, tailer *Tailer, path string) func() []string {
+	t.Helper()
+	var (
+		mu   sync.Mutex
+		msgs []string
+	)
+	done := make(chan struct{})
+	go func() {
+		tailer.openFile(path, func(event map[string]interface{}, tok *ack.Token) {
+			mu.Lock()
+			msgs = append(msgs, event["msg"].(string))
+			mu.Unlock()
+			tok.Ack()
+		})
+		close(done)
+	}()
+	t.Cleanup(func() {
+		tailer.Shutdown()
+		<-done
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), msgs...)
+	}
+}
+
+func TestTailerRestartsTruncatedFile(t *testing.T) {
+	tmpFile := createTempLogFile(t, []string{`{"msg":"old one"}`, `{"msg":"old two"}`})
+	tailer := NewTailer(tmpFile, "", false, "beginning")
+	tailer.pipeline = "logs"
+	tailer.readInterval = 20 * time.Millisecond
+	received := tailFile(t, tailer, tmpFile)
+	waitForMessages(t, received, 2, 2*time.Second)
+
+	if err := os.Truncate(tmpFile, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendLine(t, tmpFile, `{"msg":"new"}`)
+	waitForMessages(t, received, 3, 2*time.Second)
+
+	if got := received()[2]; got != "new" {
+		t.Errorf("expected the line written after truncation, got %q", got)
+	}
+	if got := testutil.ToFloat64(metrics.FilesTruncated.WithLabelValues("logs", tmpFile)); got != 1 {
+		t.Errorf("expected 1 truncation to be counted, got %v", got)
+	}
+}
+
+func TestTailerIgnoresSavedOffsetPastEnd(t *testing.T) {
+	chdirTemp(t)
+
+	tmpFile := createTempLogFile(t, []string{`{"msg":"after truncation"}`})
+	saveCheckpoint(t, tmpFile, 1000)
+
+	tailer := NewTailer(tmpFile, "", true, "latest")
+	tailer.readInterval = 20 * time.Millisecond
+	received := tailFile(t, tailer, tmpFile)
+	waitForMessages(t, received, 1, 2*time.Second)
+
+	if got := checkpointOf(t, tmpFile).Offset; got != int64(len(`{"msg":"after truncation"}`)+1) {
+		t.Errorf("expected the offset to be saved from the start of the file, got %d", got)
+	}
+}
+
+func TestTailerRereadsFailedLines(t *testing.T) {
+	chdirTemp(t)
+
+	tmpFile := createTempLogFile(t, []string{`{"msg":"one"}`, `{"msg":"two"}`, `{"msg":"three"}`})
+	tailer := NewTailer(tmpFile, "", true, "beginning")
+	tailer.readInterval = 20 * time.Millisecond
+	tailer.retryDelay = 50 * time.Millisecond
+
+	var (
+		mu     sync.Mutex
+		msgs   []string
+		failed bool
+	)
+	done := make(chan struct{})
+	go func() {
+		tailer.openFile(tmpFile, func(event map[string]interface{}, tok *ack.Token) {
+			mu.Lock()
+			defer mu.Unlock()
+			msg := event["msg"].(string)
+			msgs = append(msgs, msg)
+			if msg == "two" && !failed {
+				failed = true
+				tok.Nack()
+				return
+			}
+			tok.Ack()
+		})
+		close(done)
+	}()
+	t.Cleanup(func() {
+		tailer.Shutdown()
+		<-done
+	})
+	received := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), msgs...)
+	}
+	waitForMessages(t, received, 4, 2*time.Second)
+
+	got := received()
+	want := []string{"one", "two", "two", "three"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestTailerDrainsRotatedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendLine(t, path, `{"msg":"before"}`)
+
+	tailer := NewTailer(filepath.Join(dir, "app.log*"), "", false, "beginning")
+	// Without rules, files outside the pod log layout are tailed too.
+	tailer.rules = nil
+	tailer.watch = false
+	tailer.scanInterval = 20 * time.Millisecond
+	tailer.readInterval = 20 * time.Millisecond
+	tailer.rotateWait = 200 * time.Millisecond
+	received := runTailer(t, tailer)
+	waitForMessages(t, received, 1, 2*time.Second)
+
+	// A writer that still holds the old file finishes writing to it after
+	// the rename, then the new file appears.
+	rotated := path + ".1"
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	appendLine(t, rotated, `{"msg":"late"}`)
+	appendLine(t, path, `{"msg":"new"}`)
+	waitForMessages(t, received, 3, 2*time.Second)
+
+	// Give a duplicate read of app.log.1 the chance to show up.
+	time.Sleep(300 * time.Millisecond)
+	got := received()
+	want := []string{"before", "late", "new"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v, got %v", want, got)
+			break
+		}
+	}
+}
+
+func TestTailerForgetsRotatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	removed := filepath.Join(dir, "app.log.2")
+	replaced := filepath.Join(dir, "app.log.1")
+	tailer := NewTailer(filepath.Join(dir, "app.log.*"), "", false, "beginning")
+	tailer.rules = nil
+	defer tailer.Shutdown()
+
+	for _, path := range []string{removed, replaced} {
+		appendLine(t, path, `{"msg":"old"}`)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tailer.markRotated(path, info)
+	}
+
+	if err := os.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+	// Write the new file before the old one goes, so it cannot reuse its
+	// inode.
+	next := filepath.Join(t.TempDir(), "next")
+	appendLine(t, next, `{"msg":"new"}`)
+	if err := os.Rename(next, replaced); err != nil {
+		t.Fatal(err)
+	}
+	tailer.scanForNewFiles(func(map[string]interface{}, *ack.Token) {})
+
+	tailer.lock.Lock()
+	defer tailer.lock.Unlock()
+	if len(tailer.rotated) != 0 {
+		t.Errorf("expected removed and replaced rotated files to be forgotten, got %v", tailer.rotated)
+	}
+	if _, ok := tailer.files[replaced]; !ok {
+		t.Errorf("expected the new file at %s to be tailed", replaced)
+	}
+}
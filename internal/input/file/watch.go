@@ -0,0 +1,179 @@
+package file
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	DefaultScanInterval = 10 * time.Second
+	DefaultReadInterval = 1 * time.Second
+)
+
+// watcher follows the directories a glob pattern can match files in, so new
+// files are found and written files are read as soon as they change.
+//
+// Directories are watched rather than files: inotify reports writes to the
+// files of a watched directory, and the watch survives files being rotated.
+type watcher struct {
+	fs *fsnotify.Watcher
+
+	mu    sync.Mutex
+	dirs  map[string]bool
+	files map[string]chan struct{}
+
+	// scan is signalled when something is created that may match the
+	// pattern.
+	scan chan struct{}
+}
+
+func newWatcher() (*watcher, error) {
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{
+		fs:    fs,
+		dirs:  make(map[string]bool),
+		files: make(map[string]chan struct{}),
+		scan:  make(chan struct{}, 1),
+	}, nil
+}
+
+// watchPattern adds a watch for every existing directory that pattern can
+// match files or further directories in, starting at the deepest directory
+// without wildcards.
+func (w *watcher) watchPattern(pattern string) {
+	parts := strings.Split(filepath.Clean(pattern), string(filepath.Separator))
+	static := len(parts) - 1
+	for i, part := range parts[:len(parts)-1] {
+		if hasMeta(part) {
+			static = i
+			break
+		}
+	}
+
+	for i := static; i < len(parts); i++ {
+		prefix := strings.Join(parts[:i], string(filepath.Separator))
+		switch {
+		case i == 0:
+			prefix = "."
+		case prefix == "":
+			prefix = string(filepath.Separator)
+		}
+		dirs := []string{prefix}
+		if hasMeta(prefix) {
+			dirs, _ = filepath.Glob(prefix)
+		}
+		for _, dir := range dirs {
+			w.add(dir)
+		}
+	}
+}
+
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+func (w *watcher) add(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.dirs[dir] {
+		return
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return
+	}
+	if err := w.fs.Add(dir); err != nil {
+		log.Printf("[Tailing] Failed to watch %s, relying on polling: %v", dir, err)
+		return
+	}
+	w.dirs[dir] = true
+}
+
+// subscribe returns a channel that is signalled when the file at path
+// changes. Only the latest subscriber of a path is signalled.
+func (w *watcher) subscribe(path string) chan struct{} {
+	ch := make(chan struct{}, 1)
+	w.mu.Lock()
+	w.files[path] = ch
+	w.mu.Unlock()
+	return ch
+}
+
+// unsubscribe removes ch unless a newer subscriber has replaced it.
+func (w *watcher) unsubscribe(path string, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.files[path] == ch {
+		delete(w.files, path)
+	}
+}
+
+// run dispatches events until ctx is done and then closes the watcher.
+func (w *watcher) run(ctx context.Context) {
+	defer func() {
+		_ = w.fs.Close()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case ev, ok := <-w.fs.Events:
+			if !ok {
+				return
+			}
+			w.dispatch(ev)
+
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return
+			}
+			// Events may have been lost, so have everything checked.
+			log.Printf("[Tailing] Watch error: %v", err)
+			w.notifyAll()
+			signal(w.scan)
+		}
+	}
+}
+
+func (w *watcher) dispatch(ev fsnotify.Event) {
+	w.mu.Lock()
+	if ch, ok := w.files[ev.Name]; ok {
+		signal(ch)
+	}
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		// The kernel drops the watch of a removed directory.
+		delete(w.dirs, ev.Name)
+	}
+	w.mu.Unlock()
+
+	if ev.Has(fsnotify.Create) {
+		signal(w.scan)
+	}
+}
+
+func (w *watcher) notifyAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ch := range w.files {
+		signal(ch)
+	}
+}
+
+// signal wakes the receiver of ch without blocking; a pending signal is
+// enough.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
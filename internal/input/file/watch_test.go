@@ -0,0 +1,144 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kpiljoong/flox/internal/ack"
+)
+
+func TestWatcherWatchesPatternDirs(t *testing.T) {
+	root := t.TempDir()
+	container := filepath.Join(root, "shop_cart_uid", "app")
+	if err := os.MkdirAll(container, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := newWatcher()
+	if err != nil {
+		t.Skipf("fsnotify unavailable: %v", err)
+	}
+	defer w.fs.Close()
+	w.watchPattern(filepath.Join(root, "*", "*", "*.log"))
+
+	var dirs []string
+	for dir := range w.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	want := []string{root, filepath.Join(root, "shop_cart_uid"), container}
+	if len(dirs) != len(want) {
+		t.Fatalf("expected watches on %v, got %v", want, dirs)
+	}
+	for i := range want {
+		if dirs[i] != want[i] {
+			t.Errorf("expected watches on %v, got %v", want, dirs)
+		}
+	}
+}
+
+// runTailer tails pattern until the test ends and returns the messages
+// received so far.
+func runTailer(t *testing.T, tailer *Tailer) func() []string {
+	t.Helper()
+	var (
+		mu   sync.Mutex
+		msgs []string
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tailer.Run(ctx, func(event map[string]interface{}, _ *ack.Token) {
+			mu.Lock()
+			msgs = append(msgs, event["msg"].(string))
+			mu.Unlock()
+		})
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), msgs...)
+	}
+}
+
+func appendLine(t *testing.T, path, line string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitForMessages(t *testing.T, received func() []string, n int, within time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(within)
+	for len(received()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d messages within %s, got %v", n, within, received())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTailerFollowsNewFilesWithWatcher(t *testing.T) {
+	if w, err := newWatcher(); err != nil {
+		t.Skipf("fsnotify unavailable: %v", err)
+	} else {
+		w.fs.Close()
+	}
+
+	root := t.TempDir()
+	tailer := NewTailer(filepath.Join(root, "*", "*", "*.log"), "", false, "beginning")
+	// Long intervals, so only notifications can deliver the lines in time.
+	tailer.scanInterval = time.Hour
+	tailer.readInterval = time.Hour
+	received := runTailer(t, tailer)
+	// Give the tailer a moment to set up its watches.
+	time.Sleep(200 * time.Millisecond)
+
+	dir := filepath.Join(root, "shop_cart_uid", "app")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// Give the watcher a moment to add the new directories.
+	time.Sleep(200 * time.Millisecond)
+	path := filepath.Join(dir, "0.log")
+	appendLine(t, path, `{"msg":"first"}`)
+	waitForMessages(t, received, 1, 3*time.Second)
+
+	appendLine(t, path, `{"msg":"second"}`)
+	waitForMessages(t, received, 2, 3*time.Second)
+}
+
+func TestTailerPollsWithoutWatcher(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "shop_cart_uid", "app")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tailer := NewTailer(filepath.Join(root, "*", "*", "*.log"), "", false, "beginning")
+	tailer.watch = false
+	tailer.scanInterval = 50 * time.Millisecond
+	tailer.readInterval = 50 * time.Millisecond
+	received := runTailer(t, tailer)
+
+	path := filepath.Join(dir, "0.log")
+	appendLine(t, path, `{"msg":"first"}`)
+	waitForMessages(t, received, 1, 2*time.Second)
+	appendLine(t, path, `{"msg":"second"}`)
+	waitForMessages(t, received, 2, 2*time.Second)
+}
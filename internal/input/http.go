@@ -1,14 +1,58 @@
 package input
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+
+	"github.com/kpiljoong/flox/internal/plugin"
 )
 
-func StartHTTP(address string, handle HandlerFunc) {
+const httpShutdownTimeout = 10 * time.Second
+
+func init() {
+	Register("http", plugin.Typed("Receives JSON events via HTTP POST",
+		func(_ context.Context, cfg HTTPConfig) (Input, error) {
+			ln, err := net.Listen("tcp", cfg.Address)
+			if err != nil {
+				return nil, err
+			}
+			return &httpInput{listener: ln}, nil
+		}))
+}
+
+// HTTPConfig configures the http input.
+type HTTPConfig struct {
+	Address string `mapstructure:"address"`
+}
+
+func (c HTTPConfig) Validate() error {
+	if c.Address == "" {
+		return errors.New("address not specified for http input")
+	}
+	return nil
+}
+
+type httpInput struct {
+	listener net.Listener
+}
+
+func (i *httpInput) Run(ctx context.Context, handle HandlerFunc) {
+	serveHTTP(ctx, i.listener, handle)
+}
+
+// Close releases the listener of an input that was never run.
+func (i *httpInput) Close() error {
+	return i.listener.Close()
+}
+
+func serveHTTP(ctx context.Context, ln net.Listener, handle HandlerFunc) {
 	r := chi.NewRouter()
 
 	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
@@ -20,12 +64,22 @@ func StartHTTP(address string, handle HandlerFunc) {
 			http.Error(w, "invalid JSON", http.StatusBadRequest)
 			return
 		}
-		handle(payload)
+		handle(payload, nil)
 		w.WriteHeader(http.StatusAccepted)
 	})
 
-	log.Printf("Listening on %s", address)
-	if err := http.ListenAndServe(address, r); err != nil {
-		log.Fatalf("Error starting server: %v", err)
+	srv := &http.Server{Handler: r}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
+
+	log.Printf("Listening on %s", ln.Addr())
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Error serving HTTP: %v", err)
 	}
 }
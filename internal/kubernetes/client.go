@@ -0,0 +1,108 @@
+// Package kubernetes watches pods through the Kubernetes API so that log
+// events can be enriched with pod metadata.
+//
+// It talks to the API server with plain HTTP requests and only understands
+// the few pod fields flox needs, which keeps client-go out of the binary.
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Client sends requests to the Kubernetes API server.
+type Client struct {
+	host      string
+	tokenFile string
+	http      *http.Client
+}
+
+// NewClient returns a client for the API server at host, such as
+// "http://127.0.0.1:8001" behind kubectl proxy. Requests are not
+// authenticated unless tokenFile is set, in which case the bearer token is
+// read from it for every request so that rotated tokens are picked up.
+func NewClient(host, tokenFile string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		host:      strings.TrimSuffix(host, "/"),
+		tokenFile: tokenFile,
+		http:      httpClient,
+	}
+}
+
+// InClusterClient returns a client that uses the pod's service account, as
+// mounted by Kubernetes.
+func InClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("service account CA contains no certificates")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return NewClient("https://"+net.JoinHostPort(host, port), serviceAccountDir+"/token",
+		&http.Client{Transport: transport}), nil
+}
+
+// get requests path and returns the response body. The caller closes it.
+func (c *Client) get(ctx context.Context, path string, query url.Values) (io.ReadCloser, error) {
+	u := c.host + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.tokenFile != "" {
+		token, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		_ = resp.Body.Close()
+		return nil, &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	return resp.Body, nil
+}
+
+// StatusError is an error response from the API server.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("kubernetes API returned %d: %s", e.Code, e.Message)
+}
@@ -0,0 +1,299 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ExcludeAnnotation opts a pod out of log collection when set to "true".
+	ExcludeAnnotation = "flox.io/exclude"
+
+	// DeletedPodRetention is how long a deleted pod stays in the cache, so
+	// that the last lines of its logs are still enriched.
+	DeletedPodRetention = 5 * time.Minute
+
+	watchTimeout = 5 * time.Minute
+	retryBackoff = 5 * time.Second
+)
+
+// Pod is the metadata of a pod that events are enriched with. The owner is
+// the workload that manages the pod: a Deployment for pods of one of its
+// ReplicaSets, otherwise the pod's controller, if any.
+type Pod struct {
+	UID         string
+	Namespace   string
+	Name        string
+	NodeName    string
+	Labels      map[string]string
+	Annotations map[string]string
+	OwnerKind   string
+	OwnerName   string
+
+	deleted time.Time
+}
+
+// Excluded reports whether the pod opted out of log collection.
+func (p *Pod) Excluded() bool {
+	return p.Annotations[ExcludeAnnotation] == "true"
+}
+
+type apiPod struct {
+	Metadata struct {
+		UID             string            `json:"uid"`
+		Namespace       string            `json:"namespace"`
+		Name            string            `json:"name"`
+		Labels          map[string]string `json:"labels"`
+		Annotations     map[string]string `json:"annotations"`
+		ResourceVersion string            `json:"resourceVersion"`
+		OwnerReferences []struct {
+			Kind       string `json:"kind"`
+			Name       string `json:"name"`
+			Controller bool   `json:"controller"`
+		} `json:"ownerReferences"`
+	} `json:"metadata"`
+	Spec struct {
+		NodeName string `json:"nodeName"`
+	} `json:"spec"`
+}
+
+func (a *apiPod) pod() *Pod {
+	p := &Pod{
+		UID:         a.Metadata.UID,
+		Namespace:   a.Metadata.Namespace,
+		Name:        a.Metadata.Name,
+		NodeName:    a.Spec.NodeName,
+		Labels:      a.Metadata.Labels,
+		Annotations: a.Metadata.Annotations,
+	}
+	for _, ref := range a.Metadata.OwnerReferences {
+		if ref.Controller {
+			p.OwnerKind, p.OwnerName = ref.Kind, ref.Name
+			break
+		}
+	}
+	// A ReplicaSet created by a Deployment is named after it plus the pod
+	// template hash.
+	if hash := p.Labels["pod-template-hash"]; p.OwnerKind == "ReplicaSet" && hash != "" {
+		if name, ok := strings.CutSuffix(p.OwnerName, "-"+hash); ok {
+			p.OwnerKind, p.OwnerName = "Deployment", name
+		}
+	}
+	return p
+}
+
+type podList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []apiPod `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// errExpired means the watch's resource version is too old and the pods
+// must be listed again.
+var errExpired = errors.New("resource version expired")
+
+// PodCache keeps the pods of a node, keyed by UID, up to date by listing
+// and then watching them.
+type PodCache struct {
+	client   *Client
+	nodeName string
+
+	mu     sync.RWMutex
+	pods   map[string]*Pod
+	synced chan struct{}
+	once   sync.Once
+}
+
+// NewPodCache returns a cache of the pods scheduled on nodeName, or of every
+// pod in the cluster if nodeName is empty. It is empty until Run is called.
+func NewPodCache(client *Client, nodeName string) *PodCache {
+	return &PodCache{
+		client:   client,
+		nodeName: nodeName,
+		pods:     make(map[string]*Pod),
+		synced:   make(chan struct{}),
+	}
+}
+
+// Get returns the pod with the given UID.
+func (c *PodCache) Get(uid string) (*Pod, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	p, ok := c.pods[uid]
+	return p, ok
+}
+
+// WaitForSync blocks until the pods have been listed once or ctx is done,
+// and reports whether they were.
+func (c *PodCache) WaitForSync(ctx context.Context) bool {
+	select {
+	case <-c.synced:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Run lists and watches pods until ctx is done. Failed requests are retried
+// after a short pause, listing the pods again when the watch cannot resume.
+func (c *PodCache) Run(ctx context.Context) {
+	var version string
+	for ctx.Err() == nil {
+		var err error
+		if version == "" {
+			version, err = c.list(ctx)
+		} else {
+			version, err = c.watch(ctx, version)
+		}
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+
+		if errors.Is(err, errExpired) {
+			version = ""
+			continue
+		}
+		log.Printf("[Kubernetes] Pod watch failed, retrying in %s: %v", retryBackoff, err)
+		version = ""
+		select {
+		case <-ctx.Done():
+		case <-time.After(retryBackoff):
+		}
+	}
+}
+
+func (c *PodCache) query() url.Values {
+	q := url.Values{}
+	if c.nodeName != "" {
+		q.Set("fieldSelector", "spec.nodeName="+c.nodeName)
+	}
+	return q
+}
+
+// list replaces the cached pods with the current ones and returns the
+// resource version to watch from.
+func (c *PodCache) list(ctx context.Context) (string, error) {
+	body, err := c.client.get(ctx, "/api/v1/pods", c.query())
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	var list podList
+	if err := json.NewDecoder(body).Decode(&list); err != nil {
+		return "", fmt.Errorf("failed to decode pod list: %w", err)
+	}
+
+	now := time.Now()
+	current := make(map[string]bool, len(list.Items))
+	c.mu.Lock()
+	for i := range list.Items {
+		p := list.Items[i].pod()
+		current[p.UID] = true
+		c.pods[p.UID] = p
+	}
+	for uid, p := range c.pods {
+		if !current[uid] && p.deleted.IsZero() {
+			p.deleted = now
+		}
+	}
+	c.prune(now)
+	c.mu.Unlock()
+
+	c.once.Do(func() { close(c.synced) })
+	log.Printf("[Kubernetes] Cached %d pods", len(list.Items))
+	if list.Metadata.ResourceVersion == "" {
+		return "", errors.New("pod list has no resource version to watch from")
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch applies pod changes from version on until the server ends the
+// watch, and returns the version to resume from.
+func (c *PodCache) watch(ctx context.Context, version string) (string, error) {
+	q := c.query()
+	q.Set("watch", "true")
+	q.Set("resourceVersion", version)
+	q.Set("allowWatchBookmarks", "true")
+	q.Set("timeoutSeconds", fmt.Sprint(int(watchTimeout.Seconds())))
+
+	body, err := c.client.get(ctx, "/api/v1/pods", q)
+	if err != nil {
+		var status *StatusError
+		if errors.As(err, &status) && status.Code == http.StatusGone {
+			return "", errExpired
+		}
+		return "", err
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		var ev watchEvent
+		if err := dec.Decode(&ev); err != nil {
+			// The server closes the stream when the watch times out.
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return version, nil
+			}
+			return "", fmt.Errorf("failed to read pod watch: %w", err)
+		}
+
+		if ev.Type == "ERROR" {
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			_ = json.Unmarshal(ev.Object, &status)
+			if status.Code == http.StatusGone {
+				return "", errExpired
+			}
+			return "", &StatusError{Code: status.Code, Message: status.Message}
+		}
+
+		var obj apiPod
+		if err := json.Unmarshal(ev.Object, &obj); err != nil {
+			return "", fmt.Errorf("failed to decode pod watch event: %w", err)
+		}
+		version = obj.Metadata.ResourceVersion
+		c.apply(ev.Type, obj.pod(), time.Now())
+	}
+}
+
+func (c *PodCache) apply(eventType string, p *Pod, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch eventType {
+	case "ADDED", "MODIFIED":
+		c.pods[p.UID] = p
+	case "DELETED":
+		if cached, ok := c.pods[p.UID]; ok {
+			cached.deleted = now
+		}
+	}
+	c.prune(now)
+}
+
+// prune drops pods that were deleted more than DeletedPodRetention ago. The
+// caller holds mu.
+func (c *PodCache) prune(now time.Time) {
+	for uid, p := range c.pods {
+		if !p.deleted.IsZero() && now.Sub(p.deleted) > DeletedPodRetention {
+			delete(c.pods, uid)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAPIServer serves the pod list and watch endpoints. Watches stream the
+// events sent on its events channel.
+type fakeAPIServer struct {
+	mu      sync.Mutex
+	pods    []map[string]interface{}
+	version string
+	lists   int
+	gone    bool
+	queries []string
+	auth    []string
+	events  chan map[string]interface{}
+}
+
+func newFakeAPIServer(t *testing.T) (*fakeAPIServer, *httptest.Server) {
+	f := &fakeAPIServer{version: "10", events: make(chan map[string]interface{}, 10)}
+	srv := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeAPIServer) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v1/pods" {
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	f.queries = append(f.queries, r.URL.RawQuery)
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	if r.URL.Query().Get("watch") != "true" {
+		f.lists++
+		list := map[string]interface{}{
+			"metadata": map[string]interface{}{"resourceVersion": f.version},
+			"items":    f.pods,
+		}
+		f.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(list)
+		return
+	}
+	gone := f.gone
+	f.gone = false
+	f.mu.Unlock()
+
+	if gone {
+		http.Error(w, `{"kind":"Status","code":410}`, http.StatusGone)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-f.events:
+			_ = enc.Encode(ev)
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func testPod(uid, name, version string, annotations map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":             uid,
+			"namespace":       "shop",
+			"name":            name,
+			"resourceVersion": version,
+			"labels":          map[string]interface{}{"app": "cart", "pod-template-hash": "7d9f"},
+			"annotations":     annotations,
+			"ownerReferences": []interface{}{
+				map[string]interface{}{"kind": "ReplicaSet", "name": "cart-7d9f", "controller": true},
+			},
+		},
+		"spec": map[string]interface{}{"nodeName": "node-1"},
+	}
+}
+
+func startCache(t *testing.T, client *Client) *PodCache {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := NewPodCache(client, "node-1")
+	done := make(chan struct{})
+	go func() {
+		cache.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	syncCtx, syncCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer syncCancel()
+	if !cache.WaitForSync(syncCtx) {
+		t.Fatal("pod cache did not sync")
+	}
+	return cache
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPodCacheListsAndWatches(t *testing.T) {
+	fake, srv := newFakeAPIServer(t)
+	fake.pods = []map[string]interface{}{testPod("uid-1", "cart-7d9f-abcde", "9", nil)}
+
+	cache := startCache(t, NewClient(srv.URL, "", srv.Client()))
+
+	pod, ok := cache.Get("uid-1")
+	if !ok {
+		t.Fatal("expected listed pod in cache")
+	}
+	if pod.Name != "cart-7d9f-abcde" || pod.NodeName != "node-1" || pod.Labels["app"] != "cart" {
+		t.Errorf("unexpected pod: %+v", pod)
+	}
+	if pod.OwnerKind != "Deployment" || pod.OwnerName != "cart" {
+		t.Errorf("expected owner Deployment cart, got %s %s", pod.OwnerKind, pod.OwnerName)
+	}
+	if pod.Excluded() {
+		t.Error("expected pod without annotation not to be excluded")
+	}
+
+	fake.events <- map[string]interface{}{
+		"type":   "ADDED",
+		"object": testPod("uid-2", "quiet", "11", map[string]interface{}{ExcludeAnnotation: "true"}),
+	}
+	waitFor(t, "added pod", func() bool {
+		p, ok := cache.Get("uid-2")
+		return ok && p.Excluded()
+	})
+
+	fake.events <- map[string]interface{}{"type": "DELETED", "object": testPod("uid-1", "cart-7d9f-abcde", "12", nil)}
+	fake.events <- map[string]interface{}{"type": "ADDED", "object": testPod("uid-3", "next", "13", nil)}
+	waitFor(t, "second added pod", func() bool {
+		_, ok := cache.Get("uid-3")
+		return ok
+	})
+	if _, ok := cache.Get("uid-1"); !ok {
+		t.Error("expected deleted pod to be kept for the retention period")
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for _, q := range fake.queries {
+		if want := "fieldSelector=spec.nodeName%3Dnode-1"; !strings.Contains(q, want) {
+			t.Errorf("expected query %q to select the node with %s", q, want)
+		}
+	}
+}
+
+func TestPodCacheRelistsWhenWatchExpires(t *testing.T) {
+	fake, srv := newFakeAPIServer(t)
+	fake.gone = true
+
+	startCache(t, NewClient(srv.URL, "", srv.Client()))
+
+	waitFor(t, "relist", func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return fake.lists >= 2
+	})
+}
+
+func TestClientSendsToken(t *testing.T) {
+	fake, srv := newFakeAPIServer(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	startCache(t, NewClient(srv.URL, tokenFile, srv.Client()))
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.auth) == 0 || fake.auth[0] != "Bearer secret" {
+		t.Errorf("expected bearer token, got %v", fake.auth)
+	}
+}
+
+func TestPodOwner(t *testing.T) {
+	tests := []struct {
+		kind, name, hash string
+		wantKind         string
+		wantName         string
+	}{
+		{"ReplicaSet", "web-5f6d", "5f6d", "Deployment", "web"},
+		{"ReplicaSet", "standalone", "", "ReplicaSet", "standalone"},
+		{"StatefulSet", "db", "", "StatefulSet", "db"},
+	}
+	for _, tt := range tests {
+		var a apiPod
+		raw := fmt.Sprintf(`{"metadata":{"labels":{"pod-template-hash":%q},"ownerReferences":[{"kind":%q,"name":%q,"controller":true}]}}`,
+			tt.hash, tt.kind, tt.name)
+		if err := json.Unmarshal([]byte(raw), &a); err != nil {
+			t.Fatal(err)
+		}
+		p := a.pod()
+		if p.OwnerKind != tt.wantKind || p.OwnerName != tt.wantName {
+			t.Errorf("owner of %s %s = %s %s, want %s %s", tt.kind, tt.name, p.OwnerKind, p.OwnerName, tt.wantKind, tt.wantName)
+		}
+	}
+}
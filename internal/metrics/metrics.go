@@ -9,29 +9,79 @@ import (
 )
 
 var (
-	EventReceived = prometheus.NewCounter(prometheus.CounterOpts{
+	EventReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "flox_events_received_total",
 		Help: "Total number of events received",
-	})
+	}, []string{"pipeline"})
 
-	EventFiltered = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "flox_events_filtered_total",
-		Help: "Total number of events filtered",
-	})
+	FilterOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flox_filter_outcomes_total",
+		Help: "Total number of events processed by a filter, by outcome (pass, drop, error, split)",
+	}, []string{"pipeline", "filter", "outcome"})
 
-	OutputSuccess = prometheus.NewCounter(prometheus.CounterOpts{
+	OutputSuccess = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "flox_output_success_total",
-		Help: "Total number of successful outputs",
-	})
+		Help: "Total number of events successfully delivered by an output",
+	}, []string{"pipeline", "output"})
 
-	OutputFailure = prometheus.NewCounter(prometheus.CounterOpts{
+	OutputFailure = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "flox_output_failure_total",
-		Help: "Total number of failed outputs",
-	})
+		Help: "Total number of events an output failed to deliver",
+	}, []string{"pipeline", "output"})
+
+	RouteMatched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flox_route_matched_total",
+		Help: "Total number of events matched by a route",
+	}, []string{"pipeline", "route"})
+
+	EventUnmatched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flox_events_unmatched_total",
+		Help: "Total number of events that matched no route",
+	}, []string{"pipeline"})
+
+	QueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "flox_queue_depth",
+		Help: "Number of events waiting in an output queue",
+	}, []string{"pipeline", "output"})
+
+	QueueDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flox_queue_dropped_total",
+		Help: "Total number of events dropped because an output queue was full",
+	}, []string{"pipeline", "output", "policy"})
+
+	UnparsedLines = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flox_input_unparsed_lines_total",
+		Help: "Total number of lines an input could not parse, by what was done with them (raw, skipped)",
+	}, []string{"pipeline", "input", "action"})
+
+	FilesSkipped = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "flox_input_files_skipped",
+		Help: "Files matching an input's path that are not tailed, by reason",
+	}, []string{"pipeline", "input", "reason"})
+
+	FilesTruncated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flox_input_files_truncated_total",
+		Help: "Total number of times a tailed file was found truncated and read again from the beginning",
+	}, []string{"pipeline", "input"})
+
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flox_config_reloads_total",
+		Help: "Total number of config reload attempts",
+	}, []string{"result"})
+
+	BufferBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "flox_buffer_bytes",
+		Help: "Bytes of events held in an output's disk buffer",
+	}, []string{"pipeline", "output"})
+
+	BufferDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flox_buffer_dropped_total",
+		Help: "Total number of events dropped because a disk buffer reached its size limit",
+	}, []string{"pipeline", "output"})
 )
 
 func InitMetricsServer() {
-	prometheus.MustRegister(EventReceived, EventFiltered, OutputSuccess, OutputFailure)
+	prometheus.MustRegister(EventReceived, FilterOutcomes, OutputSuccess, OutputFailure, RouteMatched, EventUnmatched, QueueDepth, QueueDropped, BufferBytes, BufferDropped, UnparsedLines, FilesSkipped, FilesTruncated, ConfigReloads)
 
 	http.Handle("/metrics", promhttp.Handler())
 	go func() {
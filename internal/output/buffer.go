@@ -0,0 +1,585 @@
+package output
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kpiljoong/flox/internal/metrics"
+)
+
+const (
+	DefaultBufferMaxSize     = 256 << 20
+	DefaultBufferSegmentSize = 8 << 20
+	DefaultBufferRetry       = 5 * time.Second
+
+	// cursorSaveInterval is how often replay progress is written to disk. A
+	// crash replays at most this much again.
+	cursorSaveInterval = time.Second
+
+	segmentExt = ".seg"
+	cursorFile = "cursor.json"
+	lockFile   = "lock"
+)
+
+var ErrBufferFull = errors.New("disk buffer is full")
+
+// DiskBuffer is a write-ahead log in front of an output. Events go straight
+// to the wrapped output while it is healthy. Once a send fails, that event
+// and every later one are appended to segment files on disk and replayed in
+// order by a background goroutine until the backlog is drained. Spilled
+// events are synced to disk before Send returns.
+//
+// A buffer holds an exclusive lock on its dir while it is open, and replay
+// only begins with Start, so a buffer built for a config that may still be
+// rejected does not deliver anything.
+type DiskBuffer struct {
+	pipeline    string
+	name        string
+	inner       Output
+	dir         string
+	maxSize     int64
+	segmentSize int64
+	retry       time.Duration
+
+	// sendMu keeps the backlog check and the send that follows it together,
+	// so no event overtakes one spilled by another queue worker.
+	sendMu sync.Mutex
+
+	mu        sync.Mutex
+	segments  []uint64
+	active    *os.File
+	activeID  uint64
+	hasActive bool
+	writeSize int64
+	// totalSize counts the bytes on disk that have not been replayed yet.
+	totalSize int64
+	cursor    bufferCursor
+	lock      *os.File
+	started   bool
+
+	// ready is closed once the buffer is open; openErr is why it failed.
+	ready   chan struct{}
+	openErr error
+
+	notify chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type bufferCursor struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// NewDiskBuffer opens the buffer in dir and recovers the events a previous
+// run left there. It fails if another buffer holds dir.
+func NewDiskBuffer(ctx context.Context, pipeline, name string, inner Output, dir string, maxSize, segmentSize int64) (*DiskBuffer, error) {
+	b := newDiskBuffer(ctx, pipeline, name, inner, dir, maxSize, segmentSize)
+	b.mu.Lock()
+	err := b.openLocked()
+	b.mu.Unlock()
+	if err != nil {
+		b.cancel()
+		return nil, err
+	}
+	close(b.ready)
+	return b, nil
+}
+
+// NewDeferredDiskBuffer returns a buffer for dir that is only opened by
+// Start, for when dir is still held by the buffer it replaces. Sends wait
+// until then.
+func NewDeferredDiskBuffer(ctx context.Context, pipeline, name string, inner Output, dir string, maxSize, segmentSize int64) *DiskBuffer {
+	return newDiskBuffer(ctx, pipeline, name, inner, dir, maxSize, segmentSize)
+}
+
+func newDiskBuffer(ctx context.Context, pipeline, name string, inner Output, dir string, maxSize, segmentSize int64) *DiskBuffer {
+	if maxSize <= 0 {
+		maxSize = DefaultBufferMaxSize
+	}
+	if segmentSize <= 0 {
+		segmentSize = DefaultBufferSegmentSize
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &DiskBuffer{
+		pipeline:    pipeline,
+		name:        name,
+		inner:       inner,
+		dir:         dir,
+		maxSize:     maxSize,
+		segmentSize: segmentSize,
+		retry:       DefaultBufferRetry,
+		ready:       make(chan struct{}),
+		notify:      make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+}
+
+// openLocked locks dir and recovers its segments. The caller holds mu.
+func (b *DiskBuffer) openLocked() error {
+	if err := os.MkdirAll(b.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create buffer dir: %w", err)
+	}
+	lock, err := lockDir(b.dir)
+	if err != nil {
+		return err
+	}
+	if err := b.recover(); err != nil {
+		_ = lock.Close()
+		return err
+	}
+	b.lock = lock
+	return nil
+}
+
+// Start opens the buffer if that was deferred and starts replaying it.
+// Starting it again does nothing.
+func (b *DiskBuffer) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.started {
+		return nil
+	}
+	if err := b.ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-b.ready:
+	default:
+		b.openErr = b.openLocked()
+		close(b.ready)
+	}
+	if b.openErr != nil {
+		return b.openErr
+	}
+	b.started = true
+	go b.replayLoop()
+	return nil
+}
+
+// wait blocks until the buffer is open.
+func (b *DiskBuffer) wait() error {
+	select {
+	case <-b.ready:
+		return b.openErr
+	case <-b.ctx.Done():
+		return b.ctx.Err()
+	}
+}
+
+func (b *DiskBuffer) Send(event map[string]interface{}) error {
+	if err := b.wait(); err != nil {
+		return err
+	}
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+
+	if !b.backlog() {
+		err := b.inner.Send(event)
+		if err == nil {
+			return nil
+		}
+		log.Printf("[Buffer] %s unavailable, spilling to disk: %v", b.name, err)
+	}
+	return b.spill([]map[string]interface{}{event})
+}
+
+func (b *DiskBuffer) SendBatch(events []map[string]interface{}) error {
+	if err := b.wait(); err != nil {
+		return err
+	}
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+
+	if !b.backlog() {
+		err := b.inner.SendBatch(events)
+		if err == nil {
+			return nil
+		}
+		log.Printf("[Buffer] %s unavailable, spilling %d event(s) to disk: %v", b.name, len(events), err)
+	}
+	return b.spill(events)
+}
+
+func (b *DiskBuffer) backlog() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.segments) > 0
+}
+
+// Flush syncs the active segment to disk and flushes the wrapped output.
+func (b *DiskBuffer) Flush() error {
+	b.mu.Lock()
+	if b.active != nil {
+		if err := b.active.Sync(); err != nil {
+			log.Printf("[Buffer] Failed to sync segment %d: %v", b.activeID, err)
+		}
+	}
+	b.mu.Unlock()
+	return b.inner.Flush()
+}
+
+// Close stops replaying, releases the active segment and the lock on dir and
+// closes the wrapped output. Buffered events stay on disk and are replayed on
+// the next start.
+func (b *DiskBuffer) Close() error {
+	b.cancel()
+	b.mu.Lock()
+	started := b.started
+	b.mu.Unlock()
+	if started {
+		<-b.done
+	}
+
+	b.mu.Lock()
+	if b.active != nil {
+		if err := b.active.Close(); err != nil {
+			log.Printf("[Buffer] Failed to close segment %d: %v", b.activeID, err)
+		}
+		b.active = nil
+	}
+	if b.lock != nil {
+		if err := b.lock.Close(); err != nil {
+			log.Printf("[Buffer] Failed to unlock %s: %v", b.dir, err)
+		}
+		b.lock = nil
+	}
+	b.mu.Unlock()
+	return b.inner.Close()
+}
+
+// spill appends events to the active segment and syncs it, so they survive
+// a crash or reboot once spill returns.
+func (b *DiskBuffer) spill(events []map[string]interface{}) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+
+		if b.totalSize+int64(len(data)) > b.maxSize {
+			metrics.BufferDropped.WithLabelValues(b.pipeline, b.name).Inc()
+			return errors.Join(b.syncLocked(), ErrBufferFull)
+		}
+
+		if b.active == nil || b.writeSize >= b.segmentSize {
+			if err := b.syncLocked(); err != nil {
+				return err
+			}
+			if err := b.rotateLocked(); err != nil {
+				return err
+			}
+		}
+
+		if _, err := b.active.Write(data); err != nil {
+			return fmt.Errorf("failed to write buffer segment: %w", err)
+		}
+		b.writeSize += int64(len(data))
+		b.totalSize += int64(len(data))
+	}
+	if err := b.syncLocked(); err != nil {
+		return err
+	}
+	metrics.BufferBytes.WithLabelValues(b.pipeline, b.name).Set(float64(b.totalSize))
+
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (b *DiskBuffer) syncLocked() error {
+	if b.active == nil {
+		return nil
+	}
+	if err := b.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync buffer segment: %w", err)
+	}
+	return nil
+}
+
+func (b *DiskBuffer) rotateLocked() error {
+	if b.active != nil {
+		if err := b.active.Close(); err != nil {
+			log.Printf("[Buffer] Failed to close segment %d: %v", b.activeID, err)
+		}
+		b.active = nil
+	}
+
+	id := uint64(1)
+	if n := len(b.segments); n > 0 {
+		id = b.segments[n-1] + 1
+	}
+	f, err := os.OpenFile(b.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create buffer segment: %w", err)
+	}
+
+	b.segments = append(b.segments, id)
+	b.active = f
+	b.activeID = id
+	b.hasActive = true
+	b.writeSize = 0
+	if len(b.segments) == 1 {
+		b.cursor = bufferCursor{Segment: id}
+	}
+	return nil
+}
+
+// recover picks up segments left behind by a previous run. New writes always
+// start a fresh segment so a torn tail from a crash is never appended to.
+func (b *DiskBuffer) recover() error {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read buffer dir: %w", err)
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		b.segments = append(b.segments, id)
+		b.totalSize += info.Size()
+	}
+	sort.Slice(b.segments, func(i, j int) bool { return b.segments[i] < b.segments[j] })
+
+	if data, err := os.ReadFile(filepath.Join(b.dir, cursorFile)); err == nil {
+		if err := json.Unmarshal(data, &b.cursor); err != nil {
+			log.Printf("[Buffer] Ignoring corrupt cursor for %s: %v", b.name, err)
+			b.cursor = bufferCursor{}
+		}
+	}
+	if len(b.segments) > 0 && b.cursor.Segment != b.segments[0] {
+		b.cursor = bufferCursor{Segment: b.segments[0]}
+	}
+	// What the cursor has passed was already replayed.
+	b.totalSize -= b.cursor.Offset
+	if b.totalSize < 0 {
+		b.totalSize = 0
+	}
+
+	if len(b.segments) > 0 {
+		log.Printf("[Buffer] Recovered %d segment(s) (%d bytes) for %s", len(b.segments), b.totalSize, b.name)
+	}
+	metrics.BufferBytes.WithLabelValues(b.pipeline, b.name).Set(float64(b.totalSize))
+	return nil
+}
+
+func (b *DiskBuffer) replayLoop() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.retry)
+	defer ticker.Stop()
+
+	for {
+		for b.replayHead() {
+		}
+
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-b.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// replayHead delivers what is currently readable from the oldest segment and
+// reports whether it made progress worth trying again immediately.
+func (b *DiskBuffer) replayHead() bool {
+	b.mu.Lock()
+	if len(b.segments) == 0 {
+		b.mu.Unlock()
+		return false
+	}
+	id := b.segments[0]
+	isActive := b.hasActive && id == b.activeID
+	limit := int64(-1)
+	if isActive {
+		limit = b.writeSize
+	}
+	offset := b.cursor.Offset
+	b.mu.Unlock()
+
+	f, err := os.Open(b.segmentPath(id))
+	if err != nil {
+		log.Printf("[Buffer] Failed to open segment %d: %v", id, err)
+		b.dropHead(id)
+		return true
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if limit < 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return false
+		}
+		limit = info.Size()
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		log.Printf("[Buffer] Failed to seek segment %d: %v", id, err)
+		return false
+	}
+
+	reader := bufio.NewReader(io.LimitReader(f, limit-offset))
+	saved := time.Now()
+	defer func() {
+		b.saveCursor(id, offset, true)
+	}()
+	for offset < limit {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// Incomplete trailing record, most likely a crash mid-write.
+			offset = limit
+			break
+		}
+
+		var event map[string]interface{}
+		if err := json.Unmarshal(line, &event); err != nil {
+			log.Printf("[Buffer] Skipping corrupt record in segment %d", id)
+		} else if err := b.inner.Send(event); err != nil {
+			if b.ctx.Err() == nil {
+				log.Printf("[Buffer] Replay to %s failed, retrying in %s: %v", b.name, b.retry, err)
+			}
+			return false
+		}
+		offset += int64(len(line))
+		persist := time.Since(saved) >= cursorSaveInterval
+		if persist {
+			saved = time.Now()
+		}
+		b.saveCursor(id, offset, persist)
+
+		if b.ctx.Err() != nil {
+			return false
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if isActive && offset < b.writeSize {
+		// More was written while we were replaying.
+		return true
+	}
+	b.removeHeadLocked(id)
+	// The head is gone, so the deferred save has nothing left to record.
+	offset = -1
+	return true
+}
+
+func (b *DiskBuffer) dropHead(id uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeHeadLocked(id)
+}
+
+func (b *DiskBuffer) removeHeadLocked(id uint64) {
+	if len(b.segments) == 0 || b.segments[0] != id {
+		return
+	}
+
+	var size int64
+	if info, err := os.Stat(b.segmentPath(id)); err == nil {
+		size = info.Size()
+	}
+	if b.cursor.Segment == id {
+		size -= b.cursor.Offset
+	}
+	if b.hasActive && id == b.activeID {
+		if b.active != nil {
+			_ = b.active.Close()
+			b.active = nil
+		}
+		b.hasActive = false
+		b.writeSize = 0
+	}
+	if err := os.Remove(b.segmentPath(id)); err != nil && !os.IsNotExist(err) {
+		log.Printf("[Buffer] Failed to remove segment %d: %v", id, err)
+	}
+
+	b.segments = b.segments[1:]
+	b.totalSize -= size
+	if b.totalSize < 0 {
+		b.totalSize = 0
+	}
+	metrics.BufferBytes.WithLabelValues(b.pipeline, b.name).Set(float64(b.totalSize))
+
+	b.cursor = bufferCursor{}
+	if len(b.segments) > 0 {
+		b.cursor.Segment = b.segments[0]
+	}
+	b.writeCursorLocked()
+}
+
+// saveCursor records replay progress through segment id, writing it to
+// disk if persist is set. A negative offset records nothing.
+func (b *DiskBuffer) saveCursor(id uint64, offset int64, persist bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if offset < 0 || len(b.segments) == 0 || b.segments[0] != id {
+		return
+	}
+	if b.cursor.Segment == id {
+		b.totalSize -= offset - b.cursor.Offset
+		if b.totalSize < 0 {
+			b.totalSize = 0
+		}
+		metrics.BufferBytes.WithLabelValues(b.pipeline, b.name).Set(float64(b.totalSize))
+	}
+	b.cursor = bufferCursor{Segment: id, Offset: offset}
+	if persist {
+		b.writeCursorLocked()
+	}
+}
+
+func (b *DiskBuffer) writeCursorLocked() {
+	data, err := json.Marshal(b.cursor)
+	if err != nil {
+		return
+	}
+	path := filepath.Join(b.dir, cursorFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		log.Printf("[Buffer] Failed to write cursor: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("[Buffer] Failed to commit cursor: %v", err)
+	}
+}
+
+func (b *DiskBuffer) segmentPath(id uint64) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
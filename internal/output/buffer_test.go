@@ -0,0 +1,236 @@
+package output_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kpiljoong/flox/internal/output"
+)
+
+type flakyOutput struct {
+	mu     sync.Mutex
+	down   bool
+	events []map[string]interface{}
+}
+
+func (o *flakyOutput) Send(event map[string]interface{}) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.down {
+		return errors.New("sink unavailable")
+	}
+	o.events = append(o.events, event)
+	return nil
+}
+
+func (o *flakyOutput) SendBatch(events []map[string]interface{}) error {
+	for _, event := range events {
+		if err := o.Send(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *flakyOutput) Flush() error { return nil }
+
+func (o *flakyOutput) Close() error { return nil }
+
+func (o *flakyOutput) setDown(down bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.down = down
+}
+
+func (o *flakyOutput) received() []map[string]interface{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]map[string]interface{}(nil), o.events...)
+}
+
+func TestDiskBuffer_ReplaysInOrderAfterRecovery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner := &flakyOutput{down: true}
+	buf, err := output.NewDiskBuffer(ctx, "test", "test", inner, t.TempDir(), 0, 64)
+	if err != nil {
+		t.Fatalf("failed to create buffer: %v", err)
+	}
+	defer func() { _ = buf.Close() }()
+	if err := buf.Start(); err != nil {
+		t.Fatalf("failed to start buffer: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := buf.Send(map[string]interface{}{"n": float64(i)}); err != nil {
+			t.Fatalf("expected event to be buffered, got %v", err)
+		}
+	}
+	if len(inner.received()) != 0 {
+		t.Fatal("expected nothing delivered while sink is down")
+	}
+
+	inner.setDown(false)
+	_ = buf.Send(map[string]interface{}{"n": float64(10)})
+
+	waitFor(t, func() bool { return len(inner.received()) == 11 })
+	for i, event := range inner.received() {
+		if event["n"] != float64(i) {
+			t.Fatalf("event %d out of order: got %v", i, event["n"])
+		}
+	}
+}
+
+func TestDiskBuffer_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	inner := &flakyOutput{down: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	buf, err := output.NewDiskBuffer(ctx, "test", "test", inner, dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create buffer: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		_ = buf.Send(map[string]interface{}{"n": float64(i)})
+	}
+	_ = buf.Close()
+	cancel()
+
+	inner.setDown(false)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	buf, err = output.NewDiskBuffer(ctx, "test", "test", inner, dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to reopen buffer: %v", err)
+	}
+	defer func() { _ = buf.Close() }()
+	if err := buf.Start(); err != nil {
+		t.Fatalf("failed to start buffer: %v", err)
+	}
+
+	waitFor(t, func() bool { return len(inner.received()) == 3 })
+	if inner.received()[0]["n"] != float64(0) {
+		t.Errorf("expected replay to start with the oldest event, got %v", inner.received()[0]["n"])
+	}
+}
+
+func TestDiskBuffer_EnforcesMaxSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner := &flakyOutput{down: true}
+	buf, err := output.NewDiskBuffer(ctx, "test", "test", inner, t.TempDir(), 32, 0)
+	if err != nil {
+		t.Fatalf("failed to create buffer: %v", err)
+	}
+	defer func() { _ = buf.Close() }()
+
+	var full bool
+	for i := 0; i < 10; i++ {
+		if err := buf.Send(map[string]interface{}{"n": float64(i)}); errors.Is(err, output.ErrBufferFull) {
+			full = true
+			break
+		}
+	}
+	if !full {
+		t.Error("expected ErrBufferFull once max size is reached")
+	}
+}
+
+func TestDiskBuffer_RestartCountsOnlyUnreplayedBytes(t *testing.T) {
+	dir := t.TempDir()
+	// Three 8 byte records, of which the cursor says two were replayed.
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000001.seg"), []byte("{\"n\":0}\n{\"n\":1}\n{\"n\":2}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cursor.json"), []byte(`{"segment":1,"offset":16}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inner := &flakyOutput{down: true}
+	buf, err := output.NewDiskBuffer(ctx, "test", "test", inner, dir, 24, 0)
+	if err != nil {
+		t.Fatalf("failed to create buffer: %v", err)
+	}
+	defer func() { _ = buf.Close() }()
+	if err := buf.Start(); err != nil {
+		t.Fatalf("failed to start buffer: %v", err)
+	}
+
+	// With a backlog the event is spilled even though the sink is back.
+	inner.setDown(false)
+	if err := buf.Send(map[string]interface{}{"n": float64(3)}); err != nil {
+		t.Fatalf("expected room for the event next to the unreplayed record, got %v", err)
+	}
+	waitFor(t, func() bool { return len(inner.received()) == 2 })
+	if got := inner.received(); got[0]["n"] != float64(2) || got[1]["n"] != float64(3) {
+		t.Errorf("expected replay to resume at the cursor, got %v", got)
+	}
+}
+
+func TestDiskBuffer_LocksDir(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	buf, err := output.NewDiskBuffer(ctx, "test", "first", &flakyOutput{}, dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create buffer: %v", err)
+	}
+	if _, err := output.NewDiskBuffer(ctx, "test", "second", &flakyOutput{}, dir, 0, 0); err == nil {
+		t.Fatal("expected a second buffer on the same dir to be rejected")
+	}
+
+	_ = buf.Close()
+	buf, err = output.NewDiskBuffer(ctx, "test", "second", &flakyOutput{}, dir, 0, 0)
+	if err != nil {
+		t.Fatalf("expected the dir to be free once the first buffer closed, got %v", err)
+	}
+	_ = buf.Close()
+}
+
+func TestDiskBuffer_DeferredOpensOnStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	old := &flakyOutput{down: true}
+	first, err := output.NewDiskBuffer(ctx, "test", "test", old, dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create buffer: %v", err)
+	}
+	_ = first.Send(map[string]interface{}{"n": float64(0)})
+
+	inner := &flakyOutput{}
+	next := output.NewDeferredDiskBuffer(ctx, "test", "test", inner, dir, 0, 0)
+	defer func() { _ = next.Close() }()
+	sent := make(chan error, 1)
+	go func() {
+		sent <- next.Send(map[string]interface{}{"n": float64(1)})
+	}()
+	select {
+	case err := <-sent:
+		t.Fatalf("expected the send to wait for the buffer to open, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_ = first.Close()
+	if err := next.Start(); err != nil {
+		t.Fatalf("failed to start buffer: %v", err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("expected the send to succeed once open, got %v", err)
+	}
+	waitFor(t, func() bool { return len(inner.received()) == 2 })
+	if got := inner.received(); got[0]["n"] != float64(0) || got[1]["n"] != float64(1) {
+		t.Errorf("expected the spilled event before the new one, got %v", got)
+	}
+}
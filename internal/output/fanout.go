@@ -0,0 +1,172 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/kpiljoong/flox/internal/ack"
+)
+
+// FanOut delivers every event to all of its outputs. Each output is expected
+// to be a QueuedOutput, so a slow or failing sink only affects its own queue;
+// an event's token resolves once every output has acked or nacked it.
+//
+// A full queue applies its own overflow policy: under block, FanOut waits
+// for room, and with it the other outputs; under a drop policy it moves on.
+type FanOut struct {
+	names   []string
+	outputs []Output
+}
+
+func NewFanOut() *FanOut {
+	return &FanOut{}
+}
+
+func (f *FanOut) Add(name string, out Output) {
+	f.names = append(f.names, name)
+	f.outputs = append(f.outputs, out)
+}
+
+// Has reports whether an output with the given name was added.
+func (f *FanOut) Has(name string) bool {
+	return f.index(name) >= 0
+}
+
+func (f *FanOut) Send(event map[string]interface{}) error {
+	return f.SendWithAck(event, nil)
+}
+
+func (f *FanOut) SendWithAck(event map[string]interface{}, tok *ack.Token) error {
+	targets := make([]int, len(f.outputs))
+	for i := range targets {
+		targets[i] = i
+	}
+	return f.send(targets, event, tok)
+}
+
+// SendTo delivers the event only to the named outputs. Unknown names are
+// ignored.
+func (f *FanOut) SendTo(names []string, event map[string]interface{}, tok *ack.Token) error {
+	targets := make([]int, 0, len(names))
+	for _, name := range names {
+		if i := f.index(name); i >= 0 {
+			targets = append(targets, i)
+		}
+	}
+	return f.send(targets, event, tok)
+}
+
+func (f *FanOut) send(targets []int, event map[string]interface{}, tok *ack.Token) error {
+	if len(targets) == 0 {
+		tok.Ack()
+		return nil
+	}
+	tok.Retain(len(targets) - 1)
+
+	for n, i := range targets {
+		// Outputs may mutate what they are given, so each gets its own copy
+		// once there is more than one.
+		ev := event
+		if n < len(targets)-1 {
+			ev = copyEvent(event)
+		}
+
+		out := f.outputs[i]
+		var err error
+		if sender, ok := out.(AckSender); ok {
+			err = sender.SendWithAck(ev, tok)
+		} else if err = out.Send(ev); err == nil {
+			tok.Ack()
+		}
+		if err != nil {
+			// Failed targets are resolved here, so callers never see an
+			// error they would have to nack for.
+			if !errors.Is(err, ErrQueueClosed) && !errors.Is(err, context.Canceled) {
+				log.Printf("[Output] Failed to queue event for %s: %v", f.names[i], err)
+			}
+			tok.Nack()
+		}
+	}
+	return nil
+}
+
+func (f *FanOut) index(name string) int {
+	for i, n := range f.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *FanOut) SendBatch(events []map[string]interface{}) error {
+	var errs []error
+	for _, event := range events {
+		if err := f.Send(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f *FanOut) Flush() error {
+	var errs []error
+	for i, out := range f.outputs {
+		if err := out.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.names[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close drains and closes all outputs concurrently so one slow sink does not
+// eat into the others' shutdown time.
+func (f *FanOut) Close() error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(f.outputs))
+	for i, out := range f.outputs {
+		wg.Add(1)
+		go func(i int, out Output) {
+			defer wg.Done()
+			if err := out.Close(); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", f.names[i], err)
+			}
+		}(i, out)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// copyEvent copies event deeply, so that outputs running concurrently never
+// share a nested map or slice.
+func copyEvent(event map[string]interface{}) map[string]interface{} {
+	dup := make(map[string]interface{}, len(event))
+	for k, v := range event {
+		dup[k] = copyValue(v)
+	}
+	return dup
+}
+
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return copyEvent(v)
+	case map[string]string:
+		dup := make(map[string]string, len(v))
+		for k, s := range v {
+			dup[k] = s
+		}
+		return dup
+	case []interface{}:
+		dup := make([]interface{}, len(v))
+		for i, e := range v {
+			dup[i] = copyValue(e)
+		}
+		return dup
+	default:
+		return v
+	}
+}
@@ -0,0 +1,180 @@
+package output_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kpiljoong/flox/internal/ack"
+	"github.com/kpiljoong/flox/internal/output"
+)
+
+func TestFanOut_DeliversToAllOutputs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := &recordingOutput{}
+	second := &recordingOutput{}
+	fanout := output.NewFanOut()
+	fanout.Add("first", output.NewQueuedOutput(ctx, "test", "first", first, output.QueueOptions{}))
+	fanout.Add("second", output.NewQueuedOutput(ctx, "test", "second", second, output.QueueOptions{}))
+
+	if err := fanout.Send(map[string]interface{}{"msg": "hello"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	waitFor(t, func() bool { return len(first.received()) == 1 && len(second.received()) == 1 })
+}
+
+func TestFanOut_FailingOutputDoesNotAffectOthers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	healthy := &recordingOutput{}
+	broken := &flakyOutput{down: true}
+	fanout := output.NewFanOut()
+	fanout.Add("broken", output.NewQueuedOutput(ctx, "test", "broken", broken, output.QueueOptions{}))
+	fanout.Add("healthy", output.NewQueuedOutput(ctx, "test", "healthy", healthy, output.QueueOptions{}))
+
+	results := make(chan bool, 1)
+	tok := ack.New(func(ok bool) { results <- ok })
+	if err := fanout.SendWithAck(map[string]interface{}{"msg": "hello"}, tok); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ok := <-results; ok {
+		t.Error("expected token to be nacked when one output fails")
+	}
+	if len(healthy.received()) != 1 {
+		t.Errorf("expected healthy output to receive the event, got %d", len(healthy.received()))
+	}
+}
+
+func TestFanOut_DropPolicyOutputDoesNotBlockOthers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stuck := &recordingOutput{release: make(chan struct{})}
+	defer close(stuck.release)
+	healthy := &recordingOutput{}
+	fanout := output.NewFanOut()
+	fanout.Add("stuck", output.NewQueuedOutput(ctx, "test", "stuck", stuck, output.QueueOptions{Capacity: 1, Policy: output.PolicyDropNewest}))
+	fanout.Add("healthy", output.NewQueuedOutput(ctx, "test", "healthy", healthy, output.QueueOptions{}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			_ = fanout.Send(map[string]interface{}{"n": i})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("fan-out blocked on the stuck output")
+	}
+	waitFor(t, func() bool { return len(healthy.received()) == 10 })
+}
+
+func TestFanOut_BlockPolicyOutputWaitsForRoom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stuck := &recordingOutput{release: make(chan struct{})}
+	healthy := &recordingOutput{}
+	fanout := output.NewFanOut()
+	fanout.Add("stuck", output.NewQueuedOutput(ctx, "test", "stuck", stuck, output.QueueOptions{Capacity: 1, Policy: output.PolicyBlock}))
+	fanout.Add("healthy", output.NewQueuedOutput(ctx, "test", "healthy", healthy, output.QueueOptions{}))
+
+	var (
+		mu     sync.Mutex
+		failed int
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			tok := ack.New(func(ok bool) {
+				if !ok {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			})
+			_ = fanout.SendWithAck(map[string]interface{}{"n": i}, tok)
+		}
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("expected the fan-out to wait for room in the blocking output")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(stuck.release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("fan-out still blocked after the output recovered")
+	}
+	waitFor(t, func() bool { return len(stuck.received()) == 5 && len(healthy.received()) == 5 })
+	mu.Lock()
+	defer mu.Unlock()
+	if failed != 0 {
+		t.Errorf("expected no events nacked for the blocking output, got %d", failed)
+	}
+}
+
+func TestFanOut_ResolvesTokenOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	held := &recordingOutput{release: make(chan struct{})}
+	queued := output.NewQueuedOutput(ctx, "test", "closed", &recordingOutput{}, output.QueueOptions{})
+	_ = queued.Close()
+	fanout := output.NewFanOut()
+	fanout.Add("closed", queued)
+	fanout.Add("held", output.NewQueuedOutput(ctx, "test", "held", held, output.QueueOptions{}))
+
+	results := make(chan bool, 2)
+	tok := ack.New(func(ok bool) { results <- ok })
+	if err := fanout.SendWithAck(map[string]interface{}{"msg": "hello"}, tok); err != nil {
+		t.Fatalf("expected failed outputs to be resolved by the fan-out, got %v", err)
+	}
+	select {
+	case <-results:
+		t.Fatal("token resolved before the held output delivered")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(held.release)
+	if ok := <-results; ok {
+		t.Error("expected the token to be nacked for the closed output")
+	}
+}
+
+func TestFanOut_CopiesNestedFields(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := &recordingOutput{}
+	second := &recordingOutput{}
+	fanout := output.NewFanOut()
+	fanout.Add("first", output.NewQueuedOutput(ctx, "test", "first", first, output.QueueOptions{}))
+	fanout.Add("second", output.NewQueuedOutput(ctx, "test", "second", second, output.QueueOptions{}))
+
+	event := map[string]interface{}{
+		"kubernetes": map[string]interface{}{"namespace": "shop"},
+		"tags":       []interface{}{map[string]interface{}{"name": "a"}},
+	}
+	_ = fanout.Send(event)
+	waitFor(t, func() bool { return len(first.received()) == 1 && len(second.received()) == 1 })
+
+	a, b := first.received()[0], second.received()[0]
+	a["kubernetes"].(map[string]interface{})["namespace"] = "changed"
+	a["tags"].([]interface{})[0].(map[string]interface{})["name"] = "changed"
+	if b["kubernetes"].(map[string]interface{})["namespace"] != "shop" || b["tags"].([]interface{})[0].(map[string]interface{})["name"] != "a" {
+		t.Errorf("expected outputs not to share nested fields, got %v", b)
+	}
+}
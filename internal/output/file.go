@@ -1,11 +1,34 @@
 package output
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"os"
+
+	"github.com/kpiljoong/flox/internal/plugin"
 )
 
+func init() {
+	Register("file", plugin.Typed("Appends events as JSON lines to a local file",
+		func(ctx context.Context, cfg FileConfig) (Output, error) {
+			return NewFileOutput(ctx, cfg.Target)
+		}))
+}
+
+// FileConfig configures the file output.
+type FileConfig struct {
+	Target string `mapstructure:"target"`
+}
+
+func (c FileConfig) Validate() error {
+	if c.Target == "" {
+		return errors.New("target not specified for file output")
+	}
+	return nil
+}
+
 type FileOutput struct {
 	file *os.File
 	ctx  context.Context
@@ -32,3 +55,30 @@ func (o *FileOutput) Send(event map[string]interface{}) error {
 		return err
 	}
 }
+
+func (o *FileOutput) SendBatch(events []map[string]interface{}) error {
+	select {
+	case <-o.ctx.Done():
+		return o.ctx.Err()
+	default:
+		var buf bytes.Buffer
+		for _, event := range events {
+			b, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			buf.Write(b)
+			buf.WriteByte('\n')
+		}
+		_, err := o.file.Write(buf.Bytes())
+		return err
+	}
+}
+
+func (o *FileOutput) Flush() error {
+	return o.file.Sync()
+}
+
+func (o *FileOutput) Close() error {
+	return o.file.Close()
+}
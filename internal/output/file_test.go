@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -89,3 +90,34 @@ func TestFileOutput_RespectsContextCancellation(t *testing.T) {
 		t.Errorf("expected no data to be written after shutdown, got %s", string(data))
 	}
 }
+
+func TestFileOutput_SendBatchAndClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batch.log")
+
+	out, err := output.NewFileOutput(context.Background(), path)
+	if err != nil {
+		t.Fatalf("failed to create FileOutput: %v", err)
+	}
+
+	events := []map[string]interface{}{{"n": 1}, {"n": 2}, {"n": 3}}
+	if err := out.SendBatch(events); err != nil {
+		t.Fatalf("failed to send batch: %v", err)
+	}
+	if err := out.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read written log: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("expected 3 lines, got %d", lines)
+	}
+	if err := out.Send(events[0]); err == nil {
+		t.Error("expected error sending after close")
+	}
+}
@@ -2,10 +2,37 @@ package output
 
 import (
 	"context"
+	"errors"
 
 	"github.com/segmentio/kafka-go"
+
+	"github.com/kpiljoong/flox/internal/plugin"
 )
 
+func init() {
+	Register("kafka", plugin.Typed("Produces events as JSON messages to a Kafka topic",
+		func(ctx context.Context, cfg KafkaConfig) (Output, error) {
+			return NewKafkaOutput(ctx, cfg.Brokers, cfg.Topic, cfg.ClientID), nil
+		}))
+}
+
+// KafkaConfig configures the kafka output.
+type KafkaConfig struct {
+	Brokers  []string `mapstructure:"brokers"`
+	Topic    string   `mapstructure:"topic"`
+	ClientID string   `mapstructure:"client_id"`
+}
+
+func (c KafkaConfig) Validate() error {
+	if len(c.Brokers) == 0 {
+		return errors.New("brokers not specified for kafka output")
+	}
+	if c.Topic == "" {
+		return errors.New("topic not specified for kafka output")
+	}
+	return nil
+}
+
 type KafkaOutput struct {
 	writer *kafka.Writer
 	topic  string
@@ -25,13 +52,29 @@ func NewKafkaOutput(ctx context.Context, brokers []string, topic string, clientI
 }
 
 func (o *KafkaOutput) Send(event map[string]interface{}) error {
+	return o.SendBatch([]map[string]interface{}{event})
+}
+
+func (o *KafkaOutput) SendBatch(events []map[string]interface{}) error {
 	select {
 	case <-o.ctx.Done():
 		return o.ctx.Err()
 	default:
-		msg := kafka.Message{
-			Value: []byte(toJSONString(event)),
+		msgs := make([]kafka.Message, 0, len(events))
+		for _, event := range events {
+			msgs = append(msgs, kafka.Message{
+				Value: []byte(toJSONString(event)),
+			})
 		}
-		return o.writer.WriteMessages(context.Background(), msg)
+		return o.writer.WriteMessages(context.Background(), msgs...)
 	}
 }
+
+// Flush is a no-op: WriteMessages is synchronous, so nothing is held back.
+func (o *KafkaOutput) Flush() error {
+	return nil
+}
+
+func (o *KafkaOutput) Close() error {
+	return o.writer.Close()
+}
@@ -10,11 +10,46 @@ import (
 	"net/http"
 	"os"
 	"time"
+
+	"github.com/kpiljoong/flox/internal/plugin"
 )
 
+func init() {
+	Register("loki", plugin.Typed("Pushes events to a Grafana Loki endpoint",
+		func(ctx context.Context, cfg LokiConfig) (Output, error) {
+			labels := map[string]string{
+				"job":  "flox",
+				"host": "local",
+			}
+			for k, v := range cfg.Labels {
+				labels[k] = v
+			}
+			out := NewLokiOutput(ctx, cfg.Target, labels)
+			out.tenant = cfg.Tenant
+			return out, nil
+		}))
+}
+
+// LokiConfig configures the loki output. Labels are added to the default
+// job and host labels. Tenant, if set, is sent as the X-Scope-OrgID header
+// to push to that tenant of a multi-tenant Loki.
+type LokiConfig struct {
+	Target string            `mapstructure:"target"`
+	Labels map[string]string `mapstructure:"labels"`
+	Tenant string            `mapstructure:"tenant"`
+}
+
+func (c LokiConfig) Validate() error {
+	if c.Target == "" {
+		return fmt.Errorf("target not specified for loki output")
+	}
+	return nil
+}
+
 type LokiOutput struct {
 	endpoint string
 	labels   map[string]string
+	tenant   string
 	client   *http.Client
 	retries  int
 	backoff  time.Duration
@@ -36,7 +71,11 @@ func NewLokiOutput(ctx context.Context, endpoint string, labels map[string]strin
 }
 
 func (o *LokiOutput) Send(event map[string]interface{}) error {
-	payload, err := o.preparePayload(event)
+	return o.SendBatch([]map[string]interface{}{event})
+}
+
+func (o *LokiOutput) SendBatch(events []map[string]interface{}) error {
+	payload, err := o.preparePayload(events)
 	if err != nil {
 		log.Printf("failed to prepare payload: %v", err)
 		return err
@@ -50,7 +89,7 @@ func (o *LokiOutput) Send(event map[string]interface{}) error {
 		default:
 			err = o.sendOnce(payload)
 			if err == nil {
-				log.Printf("Successfully sent event to Loki: %s\n", string(payload))
+				log.Printf("Successfully sent %d event(s) to Loki", len(events))
 				return nil
 			}
 			lastErr = err
@@ -60,12 +99,26 @@ func (o *LokiOutput) Send(event map[string]interface{}) error {
 	return fmt.Errorf("all retries failed: %w", lastErr)
 }
 
+// Flush is a no-op: every push is sent synchronously.
+func (o *LokiOutput) Flush() error {
+	return nil
+}
+
+func (o *LokiOutput) Close() error {
+	o.cancel()
+	o.client.CloseIdleConnections()
+	return nil
+}
+
 func (o *LokiOutput) sendOnce(payload []byte) error {
 	req, err := http.NewRequestWithContext(o.ctx, http.MethodPost, o.endpoint+"/loki/api/v1/push", bytes.NewBuffer(payload))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if o.tenant != "" {
+		req.Header.Set("X-Scope-OrgID", o.tenant)
+	}
 
 	resp, err := o.client.Do(req)
 	if err != nil {
@@ -83,15 +136,15 @@ func (o *LokiOutput) sendOnce(payload []byte) error {
 	return nil
 }
 
-func (o *LokiOutput) preparePayload(event map[string]interface{}) ([]byte, error) {
+func (o *LokiOutput) preparePayload(events []map[string]interface{}) ([]byte, error) {
+	ts := fmt.Sprintf("%d000000", time.Now().UnixMilli()) // nanoseconds required
+	values := make([][]string, 0, len(events))
+	for _, event := range events {
+		values = append(values, []string{ts, toJSONString(event)})
+	}
 	stream := map[string]interface{}{
 		"stream": o.getLabels(),
-		"values": [][]string{
-			{
-				fmt.Sprintf("%d000000", time.Now().UnixMilli()), // nanoseconds required
-				toJSONString(event),
-			},
-		},
+		"values": values,
 	}
 
 	payload := map[string]interface{}{
@@ -2,6 +2,7 @@ package output_test
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -54,3 +55,54 @@ func TestLokiOutput_Send_RespectsContextCancel(t *testing.T) {
 		t.Error("expected no request to be sent to Loki after cancellation")
 	}
 }
+
+func TestLokiOutput_SendBatch_SinglePush(t *testing.T) {
+	var requests int
+	var values int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var payload struct {
+			Streams []struct {
+				Values [][]string `json:"values"`
+			} `json:"streams"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err == nil && len(payload.Streams) == 1 {
+			values = len(payload.Streams[0].Values)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	out := output.NewLokiOutput(context.Background(), server.URL, map[string]string{"job": "test"})
+	defer func() { _ = out.Close() }()
+
+	err := out.SendBatch([]map[string]interface{}{{"msg": "one"}, {"msg": "two"}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if requests != 1 || values != 2 {
+		t.Errorf("expected 1 push with 2 values, got %d push(es) with %d values", requests, values)
+	}
+}
+
+func TestLokiOutput_SendsTenant(t *testing.T) {
+	var tenant string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = r.Header.Get("X-Scope-OrgID")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	out, err := output.NewOutput(context.Background(), "loki", map[string]interface{}{"target": server.URL, "tenant": "payments"})
+	if err != nil {
+		t.Fatalf("failed to create output: %v", err)
+	}
+	defer func() { _ = out.Close() }()
+
+	if err := out.Send(map[string]interface{}{"msg": "paid"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if tenant != "payments" {
+		t.Errorf("expected X-Scope-OrgID payments, got %q", tenant)
+	}
+}
@@ -0,0 +1,297 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kpiljoong/flox/internal/ack"
+	"github.com/kpiljoong/flox/internal/metrics"
+)
+
+type OverflowPolicy string
+
+const (
+	PolicyBlock      OverflowPolicy = "block"
+	PolicyDropNewest OverflowPolicy = "drop_newest"
+	PolicyDropOldest OverflowPolicy = "drop_oldest"
+)
+
+const (
+	DefaultQueueCapacity = 1024
+	DefaultQueueWorkers  = 1
+	DefaultBatchSize     = 1
+)
+
+var ErrQueueClosed = errors.New("output queue is closed")
+
+type QueueOptions struct {
+	Capacity  int
+	Workers   int
+	Policy    OverflowPolicy
+	BatchSize int
+	// Linger is how long a worker waits for a batch to fill up before
+	// sending what it has. Zero sends whatever is already queued.
+	Linger time.Duration
+}
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch OverflowPolicy(s) {
+	case "":
+		return PolicyBlock, nil
+	case PolicyBlock, PolicyDropNewest, PolicyDropOldest:
+		return OverflowPolicy(s), nil
+	default:
+		return "", fmt.Errorf("unsupported queue policy: %s", s)
+	}
+}
+
+// AckSender is implemented by outputs that report delivery back to the
+// event's source through its ack token.
+type AckSender interface {
+	SendWithAck(event map[string]interface{}, tok *ack.Token) error
+}
+
+type queuedEvent struct {
+	event map[string]interface{}
+	tok   *ack.Token
+}
+
+// QueuedOutput decouples callers from a slow output. Send only enqueues the
+// event; a pool of workers delivers queued events to the wrapped output in
+// batches and acks or nacks their tokens.
+type QueuedOutput struct {
+	pipeline string
+	name     string
+	inner    Output
+	queue    chan queuedEvent
+	opts     QueueOptions
+	ctx      context.Context
+	wg       sync.WaitGroup
+	mu       sync.RWMutex
+	closed   bool
+}
+
+func NewQueuedOutput(ctx context.Context, pipeline, name string, inner Output, opts QueueOptions) *QueuedOutput {
+	if opts.Capacity <= 0 {
+		opts.Capacity = DefaultQueueCapacity
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultQueueWorkers
+	}
+	if opts.Policy == "" {
+		opts.Policy = PolicyBlock
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	q := &QueuedOutput{
+		pipeline: pipeline,
+		name:     name,
+		inner:    inner,
+		queue:    make(chan queuedEvent, opts.Capacity),
+		opts:     opts,
+		ctx:      ctx,
+	}
+	for i := 0; i < opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	return q
+}
+
+func (q *QueuedOutput) Send(event map[string]interface{}) error {
+	return q.SendWithAck(event, nil)
+}
+
+func (q *QueuedOutput) SendBatch(events []map[string]interface{}) error {
+	for _, event := range events {
+		if err := q.SendWithAck(event, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *QueuedOutput) SendWithAck(event map[string]interface{}, tok *ack.Token) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	defer q.updateDepth()
+
+	item := queuedEvent{event: event, tok: tok}
+	switch q.opts.Policy {
+	case PolicyDropNewest:
+		select {
+		case <-q.ctx.Done():
+			return q.ctx.Err()
+		case q.queue <- item:
+		default:
+			q.drop(item)
+		}
+		return nil
+
+	case PolicyDropOldest:
+		for {
+			select {
+			case <-q.ctx.Done():
+				return q.ctx.Err()
+			case q.queue <- item:
+				return nil
+			default:
+			}
+			// Make room by discarding the oldest queued event, unless a
+			// worker beat us to it.
+			select {
+			case old := <-q.queue:
+				q.drop(old)
+			default:
+			}
+		}
+
+	default:
+		select {
+		case <-q.ctx.Done():
+			return q.ctx.Err()
+		case q.queue <- item:
+			return nil
+		}
+	}
+}
+
+// drop discards an event under a drop policy. The token is acked because the
+// loss was configured explicitly; holding it would stall the source's
+// checkpoint forever.
+func (q *QueuedOutput) drop(item queuedEvent) {
+	metrics.QueueDropped.WithLabelValues(q.pipeline, q.name, string(q.opts.Policy)).Inc()
+	item.tok.Ack()
+}
+
+// Flush flushes the wrapped output. Events still in the queue are only
+// guaranteed to be delivered by Close.
+func (q *QueuedOutput) Flush() error {
+	return q.inner.Flush()
+}
+
+// Close stops accepting events, waits for the workers to deliver everything
+// already queued, then flushes and closes the wrapped output. Cancelling the
+// queue's context aborts the drain.
+func (q *QueuedOutput) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.queue)
+	q.mu.Unlock()
+
+	q.wg.Wait()
+	if err := q.inner.Flush(); err != nil {
+		log.Printf("[Queue] Failed to flush %s: %v", q.name, err)
+	}
+	return q.inner.Close()
+}
+
+func (q *QueuedOutput) worker() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case item, ok := <-q.queue:
+			if !ok {
+				return
+			}
+			batch := q.collect(item)
+			q.updateDepth()
+			if !q.deliver(batch) {
+				return
+			}
+		}
+	}
+}
+
+// collect fills a batch starting with first, waiting up to the linger time
+// for more events to arrive.
+func (q *QueuedOutput) collect(first queuedEvent) []queuedEvent {
+	batch := []queuedEvent{first}
+	if q.opts.BatchSize <= 1 {
+		return batch
+	}
+
+	var linger <-chan time.Time
+	if q.opts.Linger > 0 {
+		timer := time.NewTimer(q.opts.Linger)
+		defer timer.Stop()
+		linger = timer.C
+	}
+
+	for len(batch) < q.opts.BatchSize {
+		if linger == nil {
+			select {
+			case item, ok := <-q.queue:
+				if !ok {
+					return batch
+				}
+				batch = append(batch, item)
+				continue
+			default:
+				return batch
+			}
+		}
+
+		select {
+		case item, ok := <-q.queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, item)
+		case <-linger:
+			return batch
+		case <-q.ctx.Done():
+			return batch
+		}
+	}
+	return batch
+}
+
+// deliver sends a batch and resolves its tokens. It returns false when the
+// queue is being torn down and the worker should exit.
+func (q *QueuedOutput) deliver(batch []queuedEvent) bool {
+	events := make([]map[string]interface{}, len(batch))
+	for i, item := range batch {
+		events[i] = item.event
+	}
+
+	if err := q.inner.SendBatch(events); err != nil {
+		if q.ctx.Err() != nil {
+			// Leave the tokens unresolved so the source replays the
+			// events after restart.
+			return false
+		}
+		log.Printf("[Queue] Failed to send %d event(s) to %s: %v", len(batch), q.name, err)
+		metrics.OutputFailure.WithLabelValues(q.pipeline, q.name).Add(float64(len(batch)))
+		for _, item := range batch {
+			item.tok.Nack()
+		}
+		return true
+	}
+
+	metrics.OutputSuccess.WithLabelValues(q.pipeline, q.name).Add(float64(len(batch)))
+	for _, item := range batch {
+		item.tok.Ack()
+	}
+	return true
+}
+
+func (q *QueuedOutput) updateDepth() {
+	metrics.QueueDepth.WithLabelValues(q.pipeline, q.name).Set(float64(len(q.queue)))
+}
@@ -0,0 +1,254 @@
+package output_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kpiljoong/flox/internal/ack"
+	"github.com/kpiljoong/flox/internal/output"
+)
+
+type recordingOutput struct {
+	mu      sync.Mutex
+	events  []map[string]interface{}
+	release chan struct{}
+}
+
+func (o *recordingOutput) Send(event map[string]interface{}) error {
+	if o.release != nil {
+		<-o.release
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event)
+	return nil
+}
+
+func (o *recordingOutput) SendBatch(events []map[string]interface{}) error {
+	for _, event := range events {
+		if err := o.Send(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *recordingOutput) Flush() error { return nil }
+
+func (o *recordingOutput) Close() error { return nil }
+
+func (o *recordingOutput) received() []map[string]interface{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]map[string]interface{}(nil), o.events...)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQueuedOutput_DeliversAsynchronously(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner := &recordingOutput{}
+	q := output.NewQueuedOutput(ctx, "test", "test", inner, output.QueueOptions{Capacity: 10, Workers: 2, Policy: output.PolicyBlock})
+
+	for i := 0; i < 5; i++ {
+		if err := q.Send(map[string]interface{}{"n": i}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	waitFor(t, func() bool { return len(inner.received()) == 5 })
+}
+
+func TestQueuedOutput_DropNewest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner := &recordingOutput{release: make(chan struct{})}
+	q := output.NewQueuedOutput(ctx, "test", "test", inner, output.QueueOptions{Capacity: 2, Workers: 1, Policy: output.PolicyDropNewest})
+
+	// The single worker holds the first event while it waits on release,
+	// leaving room for exactly two more in the queue.
+	_ = q.Send(map[string]interface{}{"n": 0})
+	time.Sleep(50 * time.Millisecond)
+	for i := 1; i <= 4; i++ {
+		_ = q.Send(map[string]interface{}{"n": i})
+	}
+	close(inner.release)
+
+	waitFor(t, func() bool { return len(inner.received()) == 3 })
+	time.Sleep(50 * time.Millisecond)
+
+	got := inner.received()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(got))
+	}
+	if got[2]["n"] != 2 {
+		t.Errorf("expected newest events to be dropped, last delivered was %v", got[2]["n"])
+	}
+}
+
+func TestQueuedOutput_DropOldest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner := &recordingOutput{release: make(chan struct{})}
+	q := output.NewQueuedOutput(ctx, "test", "test", inner, output.QueueOptions{Capacity: 2, Workers: 1, Policy: output.PolicyDropOldest})
+
+	_ = q.Send(map[string]interface{}{"n": 0})
+	time.Sleep(50 * time.Millisecond)
+	for i := 1; i <= 4; i++ {
+		_ = q.Send(map[string]interface{}{"n": i})
+	}
+	close(inner.release)
+
+	waitFor(t, func() bool { return len(inner.received()) == 3 })
+	time.Sleep(50 * time.Millisecond)
+
+	got := inner.received()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(got))
+	}
+	if got[1]["n"] != 3 || got[2]["n"] != 4 {
+		t.Errorf("expected oldest events to be dropped, got %v and %v", got[1]["n"], got[2]["n"])
+	}
+}
+
+func TestQueuedOutput_BlockRespectsContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	inner := &recordingOutput{release: make(chan struct{})}
+	defer close(inner.release)
+	q := output.NewQueuedOutput(ctx, "test", "test", inner, output.QueueOptions{Capacity: 1, Workers: 1, Policy: output.PolicyBlock})
+
+	_ = q.Send(map[string]interface{}{"n": 0})
+	time.Sleep(50 * time.Millisecond)
+	_ = q.Send(map[string]interface{}{"n": 1})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- q.Send(map[string]interface{}{"n": 2})
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked Send did not return after cancel")
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	if p, err := output.ParseOverflowPolicy(""); err != nil || p != output.PolicyBlock {
+		t.Errorf("expected default policy block, got %q (%v)", p, err)
+	}
+	if _, err := output.ParseOverflowPolicy("explode"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func TestQueuedOutput_ResolvesAckTokens(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner := &flakyOutput{}
+	q := output.NewQueuedOutput(ctx, "test", "test", inner, output.QueueOptions{Capacity: 10, Workers: 1, Policy: output.PolicyBlock})
+
+	results := make(chan bool, 2)
+	_ = q.SendWithAck(map[string]interface{}{"n": 0}, ack.New(func(ok bool) { results <- ok }))
+	if ok := <-results; !ok {
+		t.Error("expected token to be acked after delivery")
+	}
+
+	inner.setDown(true)
+	_ = q.SendWithAck(map[string]interface{}{"n": 1}, ack.New(func(ok bool) { results <- ok }))
+	if ok := <-results; ok {
+		t.Error("expected token to be nacked after a failed delivery")
+	}
+}
+
+type batchRecordingOutput struct {
+	recordingOutput
+	batches []int
+	closed  bool
+}
+
+func (o *batchRecordingOutput) SendBatch(events []map[string]interface{}) error {
+	o.mu.Lock()
+	o.batches = append(o.batches, len(events))
+	o.mu.Unlock()
+	return o.recordingOutput.SendBatch(events)
+}
+
+func (o *batchRecordingOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	return nil
+}
+
+func TestQueuedOutput_BatchesUpToSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner := &batchRecordingOutput{}
+	q := output.NewQueuedOutput(ctx, "test", "test", inner, output.QueueOptions{
+		Capacity:  10,
+		Workers:   1,
+		BatchSize: 3,
+		Linger:    200 * time.Millisecond,
+	})
+
+	for i := 0; i < 5; i++ {
+		_ = q.Send(map[string]interface{}{"n": i})
+	}
+
+	waitFor(t, func() bool { return len(inner.received()) == 5 })
+	inner.mu.Lock()
+	defer inner.mu.Unlock()
+	if len(inner.batches) != 2 || inner.batches[0] != 3 || inner.batches[1] != 2 {
+		t.Errorf("expected batches of 3 and 2, got %v", inner.batches)
+	}
+}
+
+func TestQueuedOutput_CloseDrainsQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner := &batchRecordingOutput{}
+	inner.release = make(chan struct{})
+	q := output.NewQueuedOutput(ctx, "test", "test", inner, output.QueueOptions{Capacity: 10, Workers: 1})
+
+	for i := 0; i < 5; i++ {
+		_ = q.Send(map[string]interface{}{"n": i})
+	}
+	close(inner.release)
+
+	if err := q.Close(); err != nil {
+		t.Fatalf("unexpected error closing queue: %v", err)
+	}
+	if got := len(inner.received()); got != 5 {
+		t.Errorf("expected all 5 queued events to be delivered on close, got %d", got)
+	}
+	if !inner.closed {
+		t.Error("expected wrapped output to be closed")
+	}
+	if err := q.Send(map[string]interface{}{"n": 5}); err != output.ErrQueueClosed {
+		t.Errorf("expected ErrQueueClosed after close, got %v", err)
+	}
+}
@@ -6,8 +6,17 @@ import (
 	"fmt"
 	"io"
 	"os"
+
+	"github.com/kpiljoong/flox/internal/plugin"
 )
 
+func init() {
+	Register("stdout", plugin.Typed("Writes events as JSON lines to standard output",
+		func(ctx context.Context, _ struct{}) (Output, error) {
+			return NewStdoutOutput(ctx), nil
+		}))
+}
+
 type StdoutOutput struct {
 	writer io.Writer
 	ctx    context.Context
@@ -40,3 +49,20 @@ func (o *StdoutOutput) Send(event map[string]interface{}) error {
 		return err
 	}
 }
+
+func (o *StdoutOutput) SendBatch(events []map[string]interface{}) error {
+	for _, event := range events {
+		if err := o.Send(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *StdoutOutput) Flush() error {
+	return nil
+}
+
+func (o *StdoutOutput) Close() error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/kpiljoong/flox/internal/filters"
+	"github.com/kpiljoong/flox/internal/metrics"
+)
+
+// splitFilter drops events without "items", emits one event per item and
+// fails on events marked "broken".
+type splitFilter struct{}
+
+func (splitFilter) Process(event map[string]interface{}) filters.Result {
+	if event["broken"] == true {
+		return filters.Fail(event, errors.New("broken event"))
+	}
+	items, _ := event["items"].([]string)
+	var out []map[string]interface{}
+	for _, item := range items {
+		out = append(out, map[string]interface{}{"item": item})
+	}
+	return filters.Split(out)
+}
+
+func TestApplyFilters(t *testing.T) {
+	chain := []namedFilter{
+		{name: "split", filter: splitFilter{}},
+		{name: "env", filter: filters.NewJSONFilter(nil, nil, map[string]string{"env": "test"})},
+	}
+	outcome := func(filter, outcome string) float64 {
+		return testutil.ToFloat64(metrics.FilterOutcomes.WithLabelValues("apply-test", filter, outcome))
+	}
+	before := map[string]float64{
+		"split": outcome("split", "split"),
+		"drop":  outcome("split", "drop"),
+		"error": outcome("split", "error"),
+		"pass":  outcome("env", "pass"),
+	}
+
+	events := applyFilters("apply-test", chain, map[string]interface{}{"items": []string{"a", "b"}})
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %v", events)
+	}
+	for i, want := range []string{"a", "b"} {
+		if events[i]["item"] != want || events[i]["env"] != "test" {
+			t.Errorf("event %d: expected item %q with env, got %v", i, want, events[i])
+		}
+	}
+
+	if events := applyFilters("apply-test", chain, map[string]interface{}{"msg": "hello"}); len(events) != 0 {
+		t.Errorf("expected event to be dropped, got %v", events)
+	}
+
+	events = applyFilters("apply-test", chain, map[string]interface{}{"broken": true})
+	if len(events) != 1 || events[0]["broken"] != true || events[0]["env"] != "test" {
+		t.Errorf("expected failed event to continue to the next filter, got %v", events)
+	}
+
+	counts := map[string]float64{"split": 1, "drop": 1, "error": 1}
+	for name, want := range counts {
+		if got := outcome("split", name) - before[name]; got != want {
+			t.Errorf("expected %v %s outcome(s) for split filter, got %v", want, name, got)
+		}
+	}
+	if got := outcome("env", "pass") - before["pass"]; got != 3 {
+		t.Errorf("expected 3 pass outcomes for env filter, got %v", got)
+	}
+}
@@ -0,0 +1,20 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/kpiljoong/flox/internal/ack"
+)
+
+func TestTagInput(t *testing.T) {
+	var got map[string]interface{}
+	handler := tagInput("pods", func(event map[string]interface{}, _ *ack.Token) {
+		got = event
+	})
+
+	handler(map[string]interface{}{"msg": "hello"}, nil)
+
+	if got[InputIDField] != "pods" {
+		t.Errorf("expected event to be tagged with input id, got %v", got[InputIDField])
+	}
+}
@@ -0,0 +1,180 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kpiljoong/flox/internal/config"
+)
+
+// Manager runs a set of named pipelines and applies config changes to them
+// as a whole: either every pipeline accepts the new config or none does.
+type Manager struct {
+	ctx    context.Context
+	outCtx context.Context
+
+	mu        sync.Mutex
+	pipelines map[string]*managedPipeline
+	wg        sync.WaitGroup
+	retiring  sync.WaitGroup
+}
+
+type managedPipeline struct {
+	p      *Pipeline
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewManager returns a manager whose inputs stop when ctx is cancelled and
+// whose outputs run on outCtx.
+func NewManager(ctx, outCtx context.Context) *Manager {
+	return &Manager{
+		ctx:       ctx,
+		outCtx:    outCtx,
+		pipelines: make(map[string]*managedPipeline),
+	}
+}
+
+// Apply brings the running pipelines in line with cfgs. It is used both for
+// the initial start and for reloads. If any pipeline config is invalid or
+// fails to build, nothing is changed and the error is returned.
+func (m *Manager) Apply(cfgs map[string]config.PipelineConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(cfgs) == 0 {
+		return fmt.Errorf("no pipelines configured")
+	}
+	for _, name := range sortedNames(cfgs) {
+		if err := Validate(cfgs[name]); err != nil {
+			return fmt.Errorf("pipeline %s: %w", name, err)
+		}
+	}
+
+	var reloads []*Reload
+	added := make(map[string]*Pipeline)
+	abort := func() {
+		for _, r := range reloads {
+			r.Abort()
+		}
+		for _, p := range added {
+			_ = p.Close()
+		}
+	}
+
+	for _, name := range sortedNames(cfgs) {
+		if running, ok := m.pipelines[name]; ok {
+			r, err := running.p.PrepareReload(cfgs[name])
+			if err != nil {
+				abort()
+				return fmt.Errorf("pipeline %s: %w", name, err)
+			}
+			reloads = append(reloads, r)
+			continue
+		}
+
+		p, err := New(m.outCtx, name, cfgs[name])
+		if err != nil {
+			abort()
+			return fmt.Errorf("pipeline %s: %w", name, err)
+		}
+		added[name] = p
+	}
+
+	for _, r := range reloads {
+		r.Commit()
+	}
+	for name, running := range m.pipelines {
+		if _, ok := cfgs[name]; !ok {
+			log.Printf("[Pipeline] Removing pipeline %s", name)
+			delete(m.pipelines, name)
+			m.retiring.Add(1)
+			go func(running *managedPipeline) {
+				defer m.retiring.Done()
+				m.retire(running)
+			}(running)
+		}
+	}
+	for name, p := range added {
+		m.start(name, p)
+	}
+	return nil
+}
+
+func (m *Manager) start(name string, p *Pipeline) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	running := &managedPipeline{p: p, cancel: cancel, done: make(chan struct{})}
+	m.pipelines[name] = running
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(running.done)
+		p.Run(ctx)
+	}()
+}
+
+// retire stops a removed pipeline's inputs and drains its outputs.
+func (m *Manager) retire(running *managedPipeline) {
+	running.cancel()
+	<-running.done
+	if err := running.p.Close(); err != nil {
+		log.Printf("[Pipeline] Failed to close pipeline %s: %v", running.p.Name(), err)
+	}
+}
+
+// Wait blocks until the inputs of every pipeline have stopped.
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+// Drain closes every pipeline's outputs concurrently. It returns false if
+// that did not finish within timeout, in which case cancelOutputs is called
+// to abandon the remaining events.
+func (m *Manager) Drain(timeout time.Duration, cancelOutputs context.CancelFunc) bool {
+	m.mu.Lock()
+	pipelines := make([]*Pipeline, 0, len(m.pipelines))
+	for _, running := range m.pipelines {
+		pipelines = append(pipelines, running.p)
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for _, p := range pipelines {
+			wg.Add(1)
+			go func(p *Pipeline) {
+				defer wg.Done()
+				if err := p.Close(); err != nil {
+					log.Printf("[Pipeline] Failed to close pipeline %s: %v", p.Name(), err)
+				}
+			}(p)
+		}
+		wg.Wait()
+		m.retiring.Wait()
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		cancelOutputs()
+		<-done
+		return false
+	}
+}
+
+func sortedNames(cfgs map[string]config.PipelineConfig) []string {
+	names := make([]string, 0, len(cfgs))
+	for name := range cfgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
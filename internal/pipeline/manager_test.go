@@ -0,0 +1,216 @@
+package pipeline_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kpiljoong/flox/internal/config"
+	"github.com/kpiljoong/flox/internal/pipeline"
+)
+
+func TestManagerApplyRejectsInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	outCtx, cancelOutputs := context.WithCancel(context.Background())
+	defer cancelOutputs()
+
+	pipelineCfg := func(name string) config.PipelineConfig {
+		return config.PipelineConfig{
+			Input: config.InputConfig{Type: "http", Address: "127.0.0.1:0"},
+			Output: config.OutputConfig{
+				Type:   "file",
+				Target: filepath.Join(dir, name+".log"),
+			},
+		}
+	}
+
+	m := pipeline.NewManager(ctx, outCtx)
+	if err := m.Apply(map[string]config.PipelineConfig{"first": pipelineCfg("first")}); err != nil {
+		t.Fatalf("failed to apply initial config: %v", err)
+	}
+
+	invalid := map[string]config.PipelineConfig{
+		"first":  pipelineCfg("first"),
+		"second": {Input: config.InputConfig{Type: "http"}},
+	}
+	if err := m.Apply(invalid); err == nil {
+		t.Fatal("expected invalid config to be rejected")
+	}
+
+	valid := map[string]config.PipelineConfig{
+		"first":  pipelineCfg("first"),
+		"second": pipelineCfg("second"),
+	}
+	if err := m.Apply(valid); err != nil {
+		t.Fatalf("failed to apply valid config after rejection: %v", err)
+	}
+
+	cancel()
+	m.Wait()
+	if !m.Drain(5*time.Second, cancelOutputs) {
+		t.Fatal("expected drain to finish in time")
+	}
+
+	for _, name := range []string{"first", "second"} {
+		if _, err := os.Stat(filepath.Join(dir, name+".log")); err != nil {
+			t.Errorf("expected %s pipeline to be running: %v", name, err)
+		}
+	}
+}
+
+func TestManagerApplyRejectsInputThatCannotStart(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	outCtx, cancelOutputs := context.WithCancel(context.Background())
+	defer cancelOutputs()
+
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	pipelineCfg := func(name, address string) config.PipelineConfig {
+		return config.PipelineConfig{
+			Input: config.InputConfig{Type: "http", Address: address},
+			Output: config.OutputConfig{
+				Type:   "file",
+				Target: filepath.Join(dir, name+".log"),
+			},
+		}
+	}
+
+	m := pipeline.NewManager(ctx, outCtx)
+	first := pipelineCfg("first", "127.0.0.1:0")
+	if err := m.Apply(map[string]config.PipelineConfig{"first": first}); err != nil {
+		t.Fatalf("failed to apply initial config: %v", err)
+	}
+
+	conflicting := map[string]config.PipelineConfig{
+		"first":  first,
+		"second": pipelineCfg("second", busy.Addr().String()),
+	}
+	if err := m.Apply(conflicting); err == nil {
+		t.Fatal("expected a reload with a port in use to be rejected")
+	}
+
+	// The rejected reload left nothing bound, so it can be retried once the
+	// port is free.
+	_ = busy.Close()
+	if err := m.Apply(conflicting); err != nil {
+		t.Fatalf("failed to apply config once the port was free: %v", err)
+	}
+
+	cancel()
+	m.Wait()
+	if !m.Drain(5*time.Second, cancelOutputs) {
+		t.Fatal("expected drain to finish in time")
+	}
+}
+
+func TestManagerReloadReplacesBufferedOutput(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	outCtx, cancelOutputs := context.WithCancel(context.Background())
+	defer cancelOutputs()
+
+	pipelineCfg := func(target string) config.PipelineConfig {
+		return config.PipelineConfig{
+			Input: config.InputConfig{Type: "http", Address: "127.0.0.1:0"},
+			Output: config.OutputConfig{
+				Type:   "file",
+				Target: filepath.Join(dir, target),
+				Buffer: config.BufferConfig{Path: filepath.Join(dir, "buffer")},
+			},
+		}
+	}
+
+	m := pipeline.NewManager(ctx, outCtx)
+	if err := m.Apply(map[string]config.PipelineConfig{"first": pipelineCfg("old.log")}); err != nil {
+		t.Fatalf("failed to apply initial config: %v", err)
+	}
+	// The buffer dir is held by the output being replaced, so the new one
+	// waits for it instead of failing or sharing it.
+	if err := m.Apply(map[string]config.PipelineConfig{"first": pipelineCfg("new.log")}); err != nil {
+		t.Fatalf("failed to reload an output with a disk buffer: %v", err)
+	}
+	// Another output cannot take the dir while the pipeline holds it.
+	shared := map[string]config.PipelineConfig{
+		"first":  pipelineCfg("new.log"),
+		"second": pipelineCfg("second.log"),
+	}
+	if err := m.Apply(shared); err == nil {
+		t.Fatal("expected a second buffer on the same dir to be rejected")
+	}
+
+	cancel()
+	m.Wait()
+	if !m.Drain(5*time.Second, cancelOutputs) {
+		t.Fatal("expected drain to finish in time")
+	}
+}
+
+func TestManagerApplyKeepsInputWhenChangeCannotStart(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	outCtx, cancelOutputs := context.WithCancel(context.Background())
+	defer cancelOutputs()
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := free.Addr().String()
+	_ = free.Close()
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	pipelineCfg := func(address string) config.PipelineConfig {
+		return config.PipelineConfig{
+			Input: config.InputConfig{ID: "web", Type: "http", Address: address},
+			Output: config.OutputConfig{
+				Type:   "file",
+				Target: filepath.Join(dir, "out.log"),
+			},
+		}
+	}
+
+	m := pipeline.NewManager(ctx, outCtx)
+	if err := m.Apply(map[string]config.PipelineConfig{"first": pipelineCfg(address)}); err != nil {
+		t.Fatalf("failed to apply initial config: %v", err)
+	}
+	if err := m.Apply(map[string]config.PipelineConfig{"first": pipelineCfg(busy.Addr().String())}); err == nil {
+		t.Fatal("expected a reload moving the input to a port in use to be rejected")
+	}
+
+	// The old input still serves on its address.
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Post("http://"+address+"/", "application/json", strings.NewReader(`{"msg":"hello"}`)); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("expected the old input to keep running: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("expected the event to be accepted, got %s", resp.Status)
+	}
+
+	cancel()
+	m.Wait()
+	if !m.Drain(5*time.Second, cancelOutputs) {
+		t.Fatal("expected drain to finish in time")
+	}
+}
@@ -0,0 +1,609 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/kpiljoong/flox/internal/ack"
+	"github.com/kpiljoong/flox/internal/config"
+	"github.com/kpiljoong/flox/internal/filters"
+	"github.com/kpiljoong/flox/internal/input"
+	_ "github.com/kpiljoong/flox/internal/input/file" // registers the file input
+	"github.com/kpiljoong/flox/internal/metrics"
+	"github.com/kpiljoong/flox/internal/output"
+	"github.com/kpiljoong/flox/internal/router"
+)
+
+// InputIDField is set on every event to the id of the input that read it.
+const InputIDField = "input_id"
+
+// Pipeline is one named input → filters → outputs chain. Pipelines share
+// nothing but the process, so each has its own queues and metric labels.
+//
+// Filters, routing and outputs live in a stage that Reload swaps atomically;
+// inputs are only restarted when their own config changes, so tailers keep
+// their open files and offsets across a reload.
+type Pipeline struct {
+	name   string
+	outCtx context.Context
+
+	mu    sync.RWMutex
+	stage *stage
+	// retired tracks stages replaced by a reload until their filters and
+	// stale outputs are closed.
+	retired sync.WaitGroup
+
+	inputsMu  sync.Mutex
+	inputCfgs []config.InputConfig
+	inputs    map[string]*runningInput
+	// prepared holds inputs that were built but not started yet.
+	prepared map[string]input.Input
+	runCtx   context.Context
+	inputsWG sync.WaitGroup
+}
+
+type stage struct {
+	filters []namedFilter
+	outputs map[string]builtOutput
+	out     output.Output
+	// inflight counts the events being handled with this stage.
+	inflight sync.WaitGroup
+}
+
+type namedFilter struct {
+	name   string
+	filter filters.Filter
+}
+
+type builtOutput struct {
+	cfg config.OutputConfig
+	out output.Output
+	// buffer is the disk buffer inside out, if one is configured.
+	buffer *output.DiskBuffer
+}
+
+type runningInput struct {
+	cfg    config.InputConfig
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New builds the filters and outputs of a pipeline. Outputs run on outCtx,
+// which should outlive the inputs so queued events can be drained.
+func New(outCtx context.Context, name string, cfg config.PipelineConfig) (*Pipeline, error) {
+	if err := Validate(cfg); err != nil {
+		return nil, err
+	}
+
+	st, _, err := buildStage(outCtx, name, cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	prepared, err := prepareInputs(input.WithPipeline(outCtx, name), cfg.AllInputs(), nil)
+	if err != nil {
+		_ = st.out.Close()
+		closeFilters(name, st.filters)
+		return nil, err
+	}
+
+	return &Pipeline{
+		name:      name,
+		outCtx:    outCtx,
+		stage:     st,
+		inputCfgs: cfg.AllInputs(),
+		inputs:    make(map[string]*runningInput),
+		prepared:  prepared,
+	}, nil
+}
+
+// Reload is a prepared config change for a running pipeline. Nothing is
+// visible to events until Commit; Abort discards the outputs built for it.
+type Reload struct {
+	p      *Pipeline
+	cfg    config.PipelineConfig
+	stage  *stage
+	stale  []output.Output
+	built  []output.Output
+	inputs map[string]input.Input
+}
+
+// PrepareReload validates cfg and builds whatever outputs changed, reusing
+// the running instance of every output whose config is unchanged. A disk
+// buffer whose dir is held by an output being replaced is only opened by
+// Commit, once that output has closed. Inputs that are new or whose config
+// changed are built too, so that one that cannot start, such as an http
+// input on a port in use, fails the reload while the old instance keeps
+// running.
+func (p *Pipeline) PrepareReload(cfg config.PipelineConfig) (*Reload, error) {
+	if err := Validate(cfg); err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	previous := p.stage.outputs
+	p.mu.RUnlock()
+
+	st, stale, err := buildStage(p.outCtx, p.name, cfg, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	var built []output.Output
+	for name, o := range st.outputs {
+		if prev, ok := previous[name]; !ok || prev.out != o.out {
+			built = append(built, o.out)
+		}
+	}
+
+	p.inputsMu.Lock()
+	current := make(map[string]config.InputConfig, len(p.inputCfgs))
+	for _, in := range p.inputCfgs {
+		current[in.ID] = in
+	}
+	p.inputsMu.Unlock()
+	inputs, err := prepareInputs(input.WithPipeline(p.outCtx, p.name), cfg.AllInputs(), current)
+	if err != nil {
+		r := &Reload{p: p, stage: st, built: built}
+		r.Abort()
+		return nil, err
+	}
+	return &Reload{p: p, cfg: cfg, stage: st, stale: stale, built: built, inputs: inputs}, nil
+}
+
+// prepareInputs builds the inputs that are not in current with the same
+// config. If one fails, those already built are closed.
+func prepareInputs(ctx context.Context, cfgs []config.InputConfig, current map[string]config.InputConfig) (map[string]input.Input, error) {
+	prepared := make(map[string]input.Input)
+	for _, in := range cfgs {
+		if cur, ok := current[in.ID]; ok && reflect.DeepEqual(cur, in) {
+			continue
+		}
+		src, err := input.NewInput(input.WithInputID(ctx, in.ID), in.Type, in.Options())
+		if err != nil {
+			closeInputs(prepared)
+			return nil, fmt.Errorf("input %s: %w", in.ID, err)
+		}
+		prepared[in.ID] = src
+	}
+	return prepared, nil
+}
+
+// closeInputs releases inputs that were built but never run.
+func closeInputs(inputs map[string]input.Input) {
+	for id, src := range inputs {
+		if c, ok := src.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Printf("[Pipeline] Failed to close unused input %s: %v", id, err)
+			}
+		}
+	}
+}
+
+// Commit swaps in the new stage, drains outputs that are no longer used,
+// starts the disk buffers of the new ones and replaces only the inputs whose
+// config changed.
+func (r *Reload) Commit() {
+	p := r.p
+
+	p.mu.Lock()
+	old := p.stage
+	p.stage = r.stage
+	p.mu.Unlock()
+
+	// Events already handled with the old stage finish with it before its
+	// filters and the outputs it no longer shares are closed.
+	p.retired.Add(1)
+	go func() {
+		defer p.retired.Done()
+		old.inflight.Wait()
+		closeFilters(p.name, old.filters)
+
+		var wg sync.WaitGroup
+		for _, out := range r.stale {
+			wg.Add(1)
+			go func(out output.Output) {
+				defer wg.Done()
+				if err := out.Close(); err != nil {
+					log.Printf("[Pipeline] %s: failed to close replaced output: %v", p.name, err)
+				}
+			}(out)
+		}
+		wg.Wait()
+		r.stage.startBuffers(p.name)
+	}()
+
+	p.updateInputs(r.cfg.AllInputs(), r.inputs)
+}
+
+// Abort releases outputs and inputs built by PrepareReload; the running
+// stage is kept.
+func (r *Reload) Abort() {
+	closeInputs(r.inputs)
+	closeFilters(r.p.name, r.stage.filters)
+	for _, out := range r.built {
+		if err := out.Close(); err != nil {
+			log.Printf("[Pipeline] %s: failed to close discarded output: %v", r.p.name, err)
+		}
+	}
+}
+
+// Validate checks a pipeline config without starting anything.
+func Validate(cfg config.PipelineConfig) error {
+	inputs := cfg.AllInputs()
+	if len(inputs) == 0 {
+		return fmt.Errorf("'input' or 'inputs' is required")
+	}
+	for i, in := range inputs {
+		if in.Type == "" {
+			return fmt.Errorf("'inputs[%d].type' is required", i)
+		}
+		if err := input.Validate(in.Type, in.Options()); err != nil {
+			return fmt.Errorf("'inputs[%d]' is invalid: %w", i, err)
+		}
+	}
+
+	for i, f := range cfg.Filters {
+		if f.Type == "" {
+			return fmt.Errorf("'filters[%d].type' is required", i)
+		}
+		if err := filters.Validate(f.Type, f.Options()); err != nil {
+			return fmt.Errorf("'filters[%d]' is invalid: %w", i, err)
+		}
+	}
+
+	outputs := cfg.AllOutputs()
+	if len(outputs) == 0 {
+		return fmt.Errorf("'output' or 'outputs' is required")
+	}
+	names := make([]string, 0, len(outputs))
+	for i, out := range outputs {
+		if out.Type == "" {
+			return fmt.Errorf("'outputs[%d].type' is required", i)
+		}
+		if err := output.Validate(out.Type, out.Options()); err != nil {
+			return fmt.Errorf("'outputs[%d]' is invalid: %w", i, err)
+		}
+		if _, err := output.ParseOverflowPolicy(out.Queue.WhenFull); err != nil {
+			return fmt.Errorf("'outputs[%d].queue.when_full' is invalid: %w", i, err)
+		}
+		names = append(names, out.Name)
+	}
+
+	if err := router.Validate(cfg.Routing, names); err != nil {
+		return fmt.Errorf("'routing' is invalid: %w", err)
+	}
+	return nil
+}
+
+func (p *Pipeline) Name() string {
+	return p.name
+}
+
+// Run starts the pipeline's inputs and blocks until ctx is cancelled and all
+// of them have stopped.
+func (p *Pipeline) Run(ctx context.Context) {
+	p.mu.RLock()
+	p.stage.startBuffers(p.name)
+	p.mu.RUnlock()
+
+	p.inputsMu.Lock()
+	p.runCtx = ctx
+	for _, in := range p.inputCfgs {
+		p.startInput(in)
+	}
+	p.inputsMu.Unlock()
+
+	<-ctx.Done()
+	p.inputsWG.Wait()
+}
+
+// updateInputs stops inputs that were removed or changed and starts the new
+// versions, which were built in advance. Inputs with an identical config
+// keep running untouched.
+func (p *Pipeline) updateInputs(cfgs []config.InputConfig, prepared map[string]input.Input) {
+	p.inputsMu.Lock()
+	defer p.inputsMu.Unlock()
+
+	previous := make(map[string]config.InputConfig, len(p.inputCfgs))
+	for _, in := range p.inputCfgs {
+		previous[in.ID] = in
+	}
+	p.inputCfgs = cfgs
+	wanted := make(map[string]config.InputConfig, len(cfgs))
+	for _, in := range cfgs {
+		wanted[in.ID] = in
+	}
+	// Inputs built for a config that was removed or changed before they
+	// started are not used.
+	for id, src := range p.prepared {
+		if in, ok := wanted[id]; !ok || !reflect.DeepEqual(in, previous[id]) {
+			closeInputs(map[string]input.Input{id: src})
+			delete(p.prepared, id)
+		}
+	}
+	for id, src := range prepared {
+		p.prepared[id] = src
+	}
+	if p.runCtx == nil {
+		return
+	}
+
+	for id, running := range p.inputs {
+		if in, ok := wanted[id]; ok && reflect.DeepEqual(in, running.cfg) {
+			continue
+		}
+		log.Printf("[Pipeline] %s: stopping input %s", p.name, id)
+		running.cancel()
+		<-running.done
+		delete(p.inputs, id)
+	}
+	for _, in := range cfgs {
+		if _, ok := p.inputs[in.ID]; !ok {
+			log.Printf("[Pipeline] %s: starting input %s", p.name, in.ID)
+			p.startInput(in)
+		}
+	}
+}
+
+// startInput runs the input built for in. It must be called with inputsMu
+// held.
+func (p *Pipeline) startInput(in config.InputConfig) {
+	ctx, cancel := context.WithCancel(p.runCtx)
+	running := &runningInput{cfg: in, cancel: cancel, done: make(chan struct{})}
+	p.inputs[in.ID] = running
+	src := p.prepared[in.ID]
+	delete(p.prepared, in.ID)
+
+	p.inputsWG.Add(1)
+	go func() {
+		defer p.inputsWG.Done()
+		defer close(running.done)
+		src.Run(input.WithPipeline(ctx, p.name), tagInput(in.ID, p.buildHandler(ctx)))
+	}()
+}
+
+// tagInput records which input produced each event.
+func tagInput(id string, handler func(map[string]interface{}, *ack.Token)) func(map[string]interface{}, *ack.Token) {
+	return func(event map[string]interface{}, tok *ack.Token) {
+		event[InputIDField] = id
+		handler(event, tok)
+	}
+}
+
+// Close drains queued events and closes the outputs, including those
+// replaced by a reload that are still draining.
+func (p *Pipeline) Close() error {
+	p.inputsMu.Lock()
+	closeInputs(p.prepared)
+	p.prepared = make(map[string]input.Input)
+	p.inputsMu.Unlock()
+
+	p.mu.RLock()
+	st := p.stage
+	p.mu.RUnlock()
+	err := st.out.Close()
+	closeFilters(p.name, st.filters)
+	p.retired.Wait()
+	return err
+}
+
+func setupFilters(ctx context.Context, pipeline string, filterConfigs []config.FilterConfig) ([]namedFilter, error) {
+	var built []namedFilter
+	for _, f := range filterConfigs {
+		filter, err := filters.NewFilter(ctx, f.Type, f.Options())
+		if err != nil {
+			closeFilters(pipeline, built)
+			return nil, fmt.Errorf("filter %s: %w", f.Name, err)
+		}
+		built = append(built, namedFilter{name: f.Name, filter: filter})
+	}
+	return built, nil
+}
+
+// buildStage creates the filters, outputs and router for cfg. Outputs whose
+// config matches one in previous are reused; the previous outputs that are
+// not reused are returned as stale. Disk buffers are not replayed until the
+// stage is started.
+func buildStage(ctx context.Context, pipeline string, cfg config.PipelineConfig, previous map[string]builtOutput) (*stage, []output.Output, error) {
+	// Buffer dirs of outputs that are about to be replaced stay locked until
+	// those are closed.
+	held := make(map[string]bool)
+	for name, prev := range previous {
+		if prev.cfg.Buffer.Path == "" {
+			continue
+		}
+		if cur, ok := findOutput(cfg, name); !ok || !reflect.DeepEqual(prev.cfg, cur) {
+			held[filepath.Clean(prev.cfg.Buffer.Path)] = true
+		}
+	}
+
+	filterList, err := setupFilters(ctx, pipeline, cfg.AllFilters())
+	if err != nil {
+		return nil, nil, err
+	}
+	st := &stage{
+		filters: filterList,
+		outputs: make(map[string]builtOutput),
+	}
+
+	fanout := output.NewFanOut()
+	discard := func() {
+		for name, o := range st.outputs {
+			if prev, ok := previous[name]; !ok || prev.out != o.out {
+				_ = o.out.Close()
+			}
+		}
+		closeFilters(pipeline, st.filters)
+	}
+
+	for _, outputCfg := range cfg.AllOutputs() {
+		if prev, ok := previous[outputCfg.Name]; ok && reflect.DeepEqual(prev.cfg, outputCfg) {
+			st.outputs[outputCfg.Name] = prev
+			fanout.Add(outputCfg.Name, prev.out)
+			continue
+		}
+
+		deferBuffer := outputCfg.Buffer.Path != "" && held[filepath.Clean(outputCfg.Buffer.Path)]
+		built, err := setupOutput(ctx, pipeline, outputCfg, deferBuffer)
+		if err != nil {
+			discard()
+			return nil, nil, fmt.Errorf("output %s: %w", outputCfg.Name, err)
+		}
+		st.outputs[outputCfg.Name] = built
+		fanout.Add(outputCfg.Name, built.out)
+	}
+
+	r, err := router.New(pipeline, cfg.Routing, fanout)
+	if err != nil {
+		discard()
+		return nil, nil, err
+	}
+	st.out = r
+
+	var stale []output.Output
+	for name, prev := range previous {
+		if cur, ok := st.outputs[name]; !ok || cur.out != prev.out {
+			stale = append(stale, prev.out)
+		}
+	}
+	return st, stale, nil
+}
+
+// findOutput returns the config of the output named name in cfg.
+func findOutput(cfg config.PipelineConfig, name string) (config.OutputConfig, bool) {
+	for _, out := range cfg.AllOutputs() {
+		if out.Name == name {
+			return out, true
+		}
+	}
+	return config.OutputConfig{}, false
+}
+
+// setupOutput builds an output and its queue. With deferBuffer, its disk
+// buffer is only opened when it is started.
+func setupOutput(ctx context.Context, pipeline string, outputCfg config.OutputConfig, deferBuffer bool) (builtOutput, error) {
+	fmt.Printf("Creating output: %s/%s (%s)...\n", pipeline, outputCfg.Name, outputCfg.Type)
+	policy, err := output.ParseOverflowPolicy(outputCfg.Queue.WhenFull)
+	if err != nil {
+		return builtOutput{}, err
+	}
+	out, err := output.NewOutput(ctx, outputCfg.Type, outputCfg.Options())
+	if err != nil {
+		return builtOutput{}, err
+	}
+	var buffer *output.DiskBuffer
+	if outputCfg.Buffer.Path != "" {
+		if deferBuffer {
+			buffer = output.NewDeferredDiskBuffer(ctx, pipeline, outputCfg.Name, out, outputCfg.Buffer.Path, outputCfg.Buffer.MaxSize, outputCfg.Buffer.SegmentSize)
+		} else if buffer, err = output.NewDiskBuffer(ctx, pipeline, outputCfg.Name, out, outputCfg.Buffer.Path, outputCfg.Buffer.MaxSize, outputCfg.Buffer.SegmentSize); err != nil {
+			_ = out.Close()
+			return builtOutput{}, err
+		}
+		out = buffer
+	}
+	return builtOutput{
+		cfg: outputCfg,
+		out: output.NewQueuedOutput(ctx, pipeline, outputCfg.Name, out, output.QueueOptions{
+			Capacity:  outputCfg.Queue.Capacity,
+			Workers:   outputCfg.Queue.Workers,
+			Policy:    policy,
+			BatchSize: outputCfg.Batch.Size,
+			Linger:    outputCfg.Batch.Linger,
+		}),
+		buffer: buffer,
+	}, nil
+}
+
+// startBuffers starts replaying the disk buffers of the stage's outputs,
+// opening those that were deferred.
+func (st *stage) startBuffers(pipeline string) {
+	for name, o := range st.outputs {
+		if o.buffer == nil {
+			continue
+		}
+		if err := o.buffer.Start(); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("[Pipeline] %s: failed to start the disk buffer of output %s: %v", pipeline, name, err)
+		}
+	}
+}
+
+func (p *Pipeline) buildHandler(ctx context.Context) func(map[string]interface{}, *ack.Token) {
+	return func(event map[string]interface{}, tok *ack.Token) {
+		// log.Printf("[Processing] Received event: %v\n", event)
+		metrics.EventReceived.WithLabelValues(p.name).Inc()
+
+		// Pin the stage rather than holding the lock while enqueueing, which
+		// may block; a reload closes the stage's outputs only once the
+		// events pinned to it are done.
+		p.mu.RLock()
+		st := p.stage
+		st.inflight.Add(1)
+		p.mu.RUnlock()
+		defer st.inflight.Done()
+
+		events := applyFilters(p.name, st.filters, event)
+
+		// A dropped event is done; a split one resolves the token only once
+		// every resulting event has been delivered.
+		if len(events) == 0 {
+			tok.Ack()
+			return
+		}
+		tok.Retain(len(events) - 1)
+
+		// Delivery happens on the output queues, which resolve the token;
+		// only enqueue errors surface here.
+		for _, event := range events {
+			var err error
+			if sender, ok := st.out.(output.AckSender); ok {
+				err = sender.SendWithAck(event, tok)
+			} else if err = st.out.Send(event); err == nil {
+				tok.Ack()
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				fmt.Printf("[%s] Error queueing event: %v\n", p.name, err)
+				tok.Nack()
+			}
+		}
+	}
+}
+
+// applyFilters runs event through every filter in order and returns what is
+// left to deliver. Each filter sees every event produced by the one before
+// it, and each outcome is counted per filter.
+func applyFilters(pipeline string, fs []namedFilter, event map[string]interface{}) []map[string]interface{} {
+	events := []map[string]interface{}{event}
+	for _, f := range fs {
+		var next []map[string]interface{}
+		for _, e := range events {
+			res := f.filter.Process(e)
+			metrics.FilterOutcomes.WithLabelValues(pipeline, f.name, res.Outcome.String()).Inc()
+			if res.Outcome == filters.OutcomeError {
+				log.Printf("[Pipeline] %s: filter %s failed, passing event on: %v", pipeline, f.name, res.Err)
+			}
+			next = append(next, res.Events...)
+		}
+		events = next
+	}
+	return events
+}
+
+// closeFilters releases filters that hold resources, such as wasm runtimes.
+func closeFilters(pipeline string, fs []namedFilter) {
+	for _, f := range fs {
+		if c, ok := f.filter.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Printf("[Pipeline] %s: failed to close filter: %v", pipeline, err)
+			}
+		}
+	}
+}
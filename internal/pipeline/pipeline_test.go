@@ -0,0 +1,81 @@
+package pipeline_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kpiljoong/flox/internal/config"
+	"github.com/kpiljoong/flox/internal/pipeline"
+)
+
+func TestValidate(t *testing.T) {
+	valid := config.PipelineConfig{
+		Input:  config.InputConfig{Type: "http", Address: ":0"},
+		Output: config.OutputConfig{Type: "stdout"},
+	}
+	if err := pipeline.Validate(valid); err != nil {
+		t.Errorf("expected valid config, got %v", err)
+	}
+
+	missingOutput := config.PipelineConfig{
+		Input: config.InputConfig{Type: "http"},
+	}
+	if err := pipeline.Validate(missingOutput); err == nil {
+		t.Error("expected error for missing output")
+	}
+
+	unknownOutput := valid
+	unknownOutput.Output = config.OutputConfig{Type: "carrier-pigeon"}
+	if err := pipeline.Validate(unknownOutput); err == nil {
+		t.Error("expected error for unregistered output type")
+	}
+
+	badFilter := valid
+	badFilter.Filters = []config.FilterConfig{{Type: "json", Settings: map[string]interface{}{"rename_field": map[string]interface{}{"a": "b"}}}}
+	if err := pipeline.Validate(badFilter); err == nil {
+		t.Error("expected error for unknown filter setting")
+	}
+
+	badPolicy := valid
+	badPolicy.Output.Queue.WhenFull = "explode"
+	if err := pipeline.Validate(badPolicy); err == nil {
+		t.Error("expected error for invalid queue policy")
+	}
+}
+
+func TestPipelinesAreIsolated(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	build := func(name string) *pipeline.Pipeline {
+		p, err := pipeline.New(ctx, name, config.PipelineConfig{
+			Input: config.InputConfig{Type: "http", Address: "127.0.0.1:0"},
+			Output: config.OutputConfig{
+				Type:   "file",
+				Target: filepath.Join(dir, name+".log"),
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to build pipeline %s: %v", name, err)
+		}
+		return p
+	}
+
+	first := build("first")
+	second := build("second")
+	if err := first.Close(); err != nil {
+		t.Fatalf("failed to close first pipeline: %v", err)
+	}
+	if err := second.Close(); err != nil {
+		t.Fatalf("failed to close second pipeline: %v", err)
+	}
+
+	for _, name := range []string{"first", "second"} {
+		if _, err := os.Stat(filepath.Join(dir, name+".log")); err != nil {
+			t.Errorf("expected %s pipeline to create its own output file: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// Kind is the family a component type belongs to.
+type Kind string
+
+const (
+	KindInput  Kind = "input"
+	KindFilter Kind = "filter"
+	KindOutput Kind = "output"
+)
+
+// Info describes a registered component type.
+type Info struct {
+	Kind        Kind
+	Type        string
+	Description string
+}
+
+// Factory builds components of one type. Decode turns the type-specific
+// settings from the YAML config into a config value and reports mistakes
+// without side effects, so it doubles as validation. New builds a component
+// from a value returned by Decode.
+type Factory[T any] struct {
+	Description string
+	Decode      func(settings map[string]interface{}) (interface{}, error)
+	New         func(ctx context.Context, cfg interface{}) (T, error)
+}
+
+// Validator is implemented by config structs that check their own values
+// after decoding.
+type Validator interface {
+	Validate() error
+}
+
+// Typed adapts a constructor that takes a config struct C into a Factory.
+// Settings are decoded into C with Decode.
+func Typed[T, C any](description string, newFn func(ctx context.Context, cfg C) (T, error)) Factory[T] {
+	return Factory[T]{
+		Description: description,
+		Decode: func(settings map[string]interface{}) (interface{}, error) {
+			var cfg C
+			if err := Decode(settings, &cfg); err != nil {
+				return nil, err
+			}
+			return cfg, nil
+		},
+		New: func(ctx context.Context, cfg interface{}) (T, error) {
+			typed, ok := cfg.(C)
+			if !ok {
+				var zero T
+				return zero, fmt.Errorf("unexpected config type %T", cfg)
+			}
+			return newFn(ctx, typed)
+		},
+	}
+}
+
+// Decode decodes settings into the struct pointed to by out. Unknown keys
+// are rejected so typos do not silently disable a setting, and durations may
+// be given as strings such as "5s". If out implements Validator it is
+// validated afterwards.
+func Decode(settings map[string]interface{}, out interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           out,
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := decoder.Decode(settings); err != nil {
+		return err
+	}
+	if v, ok := out.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// Registry holds the factories for one kind of component.
+type Registry[T any] struct {
+	kind      Kind
+	mu        sync.RWMutex
+	factories map[string]Factory[T]
+}
+
+var (
+	registriesMu sync.Mutex
+	registries   []interface{ Types() []Info }
+)
+
+// NewRegistry creates a registry for kind. Its types are included in List.
+func NewRegistry[T any](kind Kind) *Registry[T] {
+	r := &Registry[T]{kind: kind, factories: make(map[string]Factory[T])}
+	registriesMu.Lock()
+	registries = append(registries, r)
+	registriesMu.Unlock()
+	return r
+}
+
+// Register makes a component type available. It is meant to be called from
+// init and panics if the type is empty or already registered.
+func (r *Registry[T]) Register(typ string, factory Factory[T]) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if typ == "" {
+		panic(fmt.Sprintf("plugin: %s type name is empty", r.kind))
+	}
+	if factory.Decode == nil || factory.New == nil {
+		panic(fmt.Sprintf("plugin: %s %q has no Decode or New", r.kind, typ))
+	}
+	if _, dup := r.factories[typ]; dup {
+		panic(fmt.Sprintf("plugin: %s %q registered twice", r.kind, typ))
+	}
+	r.factories[typ] = factory
+}
+
+// Lookup returns the factory registered for typ.
+func (r *Registry[T]) Lookup(typ string) (Factory[T], error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	f, ok := r.factories[typ]
+	if !ok {
+		return Factory[T]{}, fmt.Errorf("unsupported %s type: %s", r.kind, typ)
+	}
+	return f, nil
+}
+
+// Validate checks that typ is registered and that settings decode for it.
+func (r *Registry[T]) Validate(typ string, settings map[string]interface{}) error {
+	f, err := r.Lookup(typ)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Decode(settings); err != nil {
+		return fmt.Errorf("invalid %s config: %w", typ, err)
+	}
+	return nil
+}
+
+// Build decodes settings and creates a component of type typ.
+func (r *Registry[T]) Build(ctx context.Context, typ string, settings map[string]interface{}) (T, error) {
+	var zero T
+	f, err := r.Lookup(typ)
+	if err != nil {
+		return zero, err
+	}
+	cfg, err := f.Decode(settings)
+	if err != nil {
+		return zero, fmt.Errorf("invalid %s config: %w", typ, err)
+	}
+	return f.New(ctx, cfg)
+}
+
+// Types lists the registered types sorted by name.
+func (r *Registry[T]) Types() []Info {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	infos := make([]Info, 0, len(r.factories))
+	for typ, f := range r.factories {
+		infos = append(infos, Info{Kind: r.kind, Type: typ, Description: f.Description})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Type < infos[j].Type })
+	return infos
+}
+
+// List returns every registered type across all registries, grouped by kind.
+func List() []Info {
+	registriesMu.Lock()
+	defer registriesMu.Unlock()
+
+	var infos []Info
+	for _, r := range registries {
+		infos = append(infos, r.Types()...)
+	}
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].Kind < infos[j].Kind })
+	return infos
+}
@@ -0,0 +1,88 @@
+package plugin_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kpiljoong/flox/internal/plugin"
+)
+
+type greeter struct {
+	greeting string
+}
+
+type greeterConfig struct {
+	Greeting string        `mapstructure:"greeting"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+}
+
+func (c greeterConfig) Validate() error {
+	if c.Greeting == "" {
+		return errors.New("greeting is required")
+	}
+	return nil
+}
+
+func newRegistry() *plugin.Registry[*greeter] {
+	r := plugin.NewRegistry[*greeter]("test")
+	r.Register("greeter", plugin.Typed("Says hello", func(_ context.Context, cfg greeterConfig) (*greeter, error) {
+		return &greeter{greeting: cfg.Greeting}, nil
+	}))
+	return r
+}
+
+func TestRegistryBuild(t *testing.T) {
+	r := newRegistry()
+
+	g, err := r.Build(context.Background(), "greeter", map[string]interface{}{
+		"greeting": "hello",
+		"timeout":  "5s",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g.greeting != "hello" {
+		t.Errorf("expected greeting 'hello', got %q", g.greeting)
+	}
+}
+
+func TestRegistryValidate(t *testing.T) {
+	r := newRegistry()
+
+	if err := r.Validate("greeter", map[string]interface{}{"greeting": "hi"}); err != nil {
+		t.Errorf("expected valid config, got %v", err)
+	}
+	if err := r.Validate("greeter", map[string]interface{}{}); err == nil {
+		t.Error("expected error when Validate fails")
+	}
+	if err := r.Validate("greeter", map[string]interface{}{"greeting": "hi", "greting": "typo"}); err == nil {
+		t.Error("expected error for unknown key")
+	}
+	if err := r.Validate("missing", nil); err == nil {
+		t.Error("expected error for unregistered type")
+	}
+}
+
+func TestRegistryRejectsDuplicates(t *testing.T) {
+	r := newRegistry()
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate registration")
+		}
+	}()
+	r.Register("greeter", plugin.Typed("Again", func(_ context.Context, _ struct{}) (*greeter, error) {
+		return &greeter{}, nil
+	}))
+}
+
+func TestListIncludesRegistry(t *testing.T) {
+	newRegistry()
+	for _, info := range plugin.List() {
+		if info.Kind == "test" && info.Type == "greeter" && info.Description == "Says hello" {
+			return
+		}
+	}
+	t.Error("expected greeter in plugin.List()")
+}
@@ -0,0 +1,196 @@
+package router
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/kpiljoong/flox/internal/ack"
+	"github.com/kpiljoong/flox/internal/config"
+	"github.com/kpiljoong/flox/internal/fieldpath"
+	"github.com/kpiljoong/flox/internal/metrics"
+	"github.com/kpiljoong/flox/internal/output"
+)
+
+type condition struct {
+	field   string
+	equals  *string
+	in      map[string]struct{}
+	matches *regexp.Regexp
+	exists  *bool
+}
+
+type route struct {
+	name    string
+	cond    condition
+	outputs []string
+}
+
+// Router is the stage after the filter chain that decides which outputs
+// receive an event. It implements output.Output so it can stand in for the
+// fan-out it wraps.
+type Router struct {
+	pipeline    string
+	routes      []route
+	defaults    []string
+	passthrough bool
+	outputs     *output.FanOut
+}
+
+// New compiles the routing rules against the given outputs. With no routes
+// configured every event is sent to every output.
+func New(pipeline string, cfg config.RoutingConfig, outputs *output.FanOut) (*Router, error) {
+	routes, err := compile(cfg, outputs.Has)
+	if err != nil {
+		return nil, err
+	}
+	return &Router{
+		pipeline:    pipeline,
+		routes:      routes,
+		defaults:    cfg.Default,
+		passthrough: len(cfg.Routes) == 0,
+		outputs:     outputs,
+	}, nil
+}
+
+// Validate checks the routing rules without building any outputs.
+func Validate(cfg config.RoutingConfig, outputNames []string) error {
+	known := make(map[string]bool, len(outputNames))
+	for _, name := range outputNames {
+		known[name] = true
+	}
+	_, err := compile(cfg, func(name string) bool { return known[name] })
+	return err
+}
+
+func compile(cfg config.RoutingConfig, hasOutput func(string) bool) ([]route, error) {
+	if len(cfg.Routes) == 0 && len(cfg.Default) > 0 {
+		return nil, fmt.Errorf("routing.default requires at least one route")
+	}
+
+	var routes []route
+	for i, rc := range cfg.Routes {
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprintf("route-%d", i)
+		}
+		if rc.When.Field == "" {
+			return nil, fmt.Errorf("route %s: 'when.field' is required", name)
+		}
+		if len(rc.Outputs) == 0 {
+			return nil, fmt.Errorf("route %s: at least one output is required", name)
+		}
+		for _, out := range rc.Outputs {
+			if !hasOutput(out) {
+				return nil, fmt.Errorf("route %s: unknown output %q", name, out)
+			}
+		}
+
+		cond := condition{
+			field:  rc.When.Field,
+			equals: rc.When.Equals,
+			exists: rc.When.Exists,
+		}
+		if len(rc.When.In) > 0 {
+			cond.in = make(map[string]struct{}, len(rc.When.In))
+			for _, v := range rc.When.In {
+				cond.in[v] = struct{}{}
+			}
+		}
+		if rc.When.Matches != "" {
+			re, err := regexp.Compile(rc.When.Matches)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: invalid pattern: %w", name, err)
+			}
+			cond.matches = re
+		}
+
+		routes = append(routes, route{name: name, cond: cond, outputs: rc.Outputs})
+	}
+
+	for _, out := range cfg.Default {
+		if !hasOutput(out) {
+			return nil, fmt.Errorf("routing.default: unknown output %q", out)
+		}
+	}
+	return routes, nil
+}
+
+func (r *Router) Send(event map[string]interface{}) error {
+	return r.SendWithAck(event, nil)
+}
+
+func (r *Router) SendWithAck(event map[string]interface{}, tok *ack.Token) error {
+	if r.passthrough {
+		return r.outputs.SendWithAck(event, tok)
+	}
+
+	targets := r.match(event)
+	if len(targets) == 0 {
+		metrics.EventUnmatched.WithLabelValues(r.pipeline).Inc()
+		targets = r.defaults
+	}
+	return r.outputs.SendTo(targets, event, tok)
+}
+
+func (r *Router) SendBatch(events []map[string]interface{}) error {
+	for _, event := range events {
+		if err := r.Send(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Router) Flush() error {
+	return r.outputs.Flush()
+}
+
+func (r *Router) Close() error {
+	return r.outputs.Close()
+}
+
+// match returns the outputs of every matching route, without duplicates.
+func (r *Router) match(event map[string]interface{}) []string {
+	var targets []string
+	seen := make(map[string]bool)
+	for _, rt := range r.routes {
+		if !rt.cond.eval(event) {
+			continue
+		}
+		metrics.RouteMatched.WithLabelValues(r.pipeline, rt.name).Inc()
+		for _, out := range rt.outputs {
+			if !seen[out] {
+				seen[out] = true
+				targets = append(targets, out)
+			}
+		}
+	}
+	return targets
+}
+
+func (c condition) eval(event map[string]interface{}) bool {
+	value, found := fieldpath.Get(event, c.field)
+	if c.exists != nil && *c.exists != found {
+		return false
+	}
+	if c.equals == nil && c.in == nil && c.matches == nil {
+		return c.exists != nil || found
+	}
+	if !found {
+		return false
+	}
+
+	str := fmt.Sprint(value)
+	if c.equals != nil && str != *c.equals {
+		return false
+	}
+	if c.in != nil {
+		if _, ok := c.in[str]; !ok {
+			return false
+		}
+	}
+	if c.matches != nil && !c.matches.MatchString(str) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package router_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/kpiljoong/flox/internal/config"
+	"github.com/kpiljoong/flox/internal/output"
+	"github.com/kpiljoong/flox/internal/router"
+)
+
+type recordingOutput struct {
+	mu     sync.Mutex
+	events []map[string]interface{}
+}
+
+func (o *recordingOutput) Send(event map[string]interface{}) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event)
+	return nil
+}
+
+func (o *recordingOutput) SendBatch(events []map[string]interface{}) error {
+	for _, event := range events {
+		_ = o.Send(event)
+	}
+	return nil
+}
+
+func (o *recordingOutput) Flush() error { return nil }
+
+func (o *recordingOutput) Close() error { return nil }
+
+func (o *recordingOutput) count() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.events)
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func setup(t *testing.T, routing config.RoutingConfig) (*router.Router, map[string]*recordingOutput) {
+	t.Helper()
+	outputs := map[string]*recordingOutput{
+		"kafka":    {},
+		"payments": {},
+		"archive":  {},
+	}
+	fanout := output.NewFanOut()
+	for _, name := range []string{"kafka", "payments", "archive"} {
+		fanout.Add(name, outputs[name])
+	}
+	r, err := router.New("test", routing, fanout)
+	if err != nil {
+		t.Fatalf("failed to build router: %v", err)
+	}
+	return r, outputs
+}
+
+func TestRouter_RoutesByContent(t *testing.T) {
+	r, outputs := setup(t, config.RoutingConfig{
+		Routes: []config.RouteConfig{
+			{Name: "errors", When: config.ConditionConfig{Field: "level", Equals: strPtr("error")}, Outputs: []string{"kafka"}},
+			{Name: "payments", When: config.ConditionConfig{Field: "kubernetes.namespace", In: []string{"payments"}}, Outputs: []string{"payments"}},
+		},
+		Default: []string{"archive"},
+	})
+
+	_ = r.Send(map[string]interface{}{"level": "error"})
+	_ = r.Send(map[string]interface{}{"kubernetes": map[string]interface{}{"namespace": "payments"}})
+	_ = r.Send(map[string]interface{}{"level": "error", "kubernetes": map[string]interface{}{"namespace": "payments"}})
+	_ = r.Send(map[string]interface{}{"level": "info"})
+
+	if got := outputs["kafka"].count(); got != 2 {
+		t.Errorf("expected 2 events routed to kafka, got %d", got)
+	}
+	if got := outputs["payments"].count(); got != 2 {
+		t.Errorf("expected 2 events routed to payments, got %d", got)
+	}
+	if got := outputs["archive"].count(); got != 1 {
+		t.Errorf("expected 1 unmatched event on the default route, got %d", got)
+	}
+}
+
+func TestRouter_MatchesPattern(t *testing.T) {
+	r, outputs := setup(t, config.RoutingConfig{
+		Routes: []config.RouteConfig{
+			{When: config.ConditionConfig{Field: "path", Matches: "^/api/"}, Outputs: []string{"kafka"}},
+		},
+	})
+
+	_ = r.Send(map[string]interface{}{"path": "/api/orders"})
+	_ = r.Send(map[string]interface{}{"path": "/healthz"})
+
+	if got := outputs["kafka"].count(); got != 1 {
+		t.Errorf("expected 1 matching event, got %d", got)
+	}
+	if got := outputs["archive"].count(); got != 0 {
+		t.Errorf("expected unmatched event to be dropped without a default route, got %d", got)
+	}
+}
+
+func TestRouter_EqualsEmptyValue(t *testing.T) {
+	r, outputs := setup(t, config.RoutingConfig{
+		Routes: []config.RouteConfig{
+			{When: config.ConditionConfig{Field: "user", Equals: strPtr("")}, Outputs: []string{"kafka"}},
+		},
+	})
+
+	_ = r.Send(map[string]interface{}{"user": ""})
+	_ = r.Send(map[string]interface{}{"user": "alice"})
+	_ = r.Send(map[string]interface{}{"msg": "no user"})
+
+	if got := outputs["kafka"].count(); got != 1 {
+		t.Errorf("expected only the event with an empty user to match, got %d", got)
+	}
+}
+
+func TestRouter_PassthroughWithoutRoutes(t *testing.T) {
+	r, outputs := setup(t, config.RoutingConfig{})
+
+	_ = r.Send(map[string]interface{}{"msg": "hello"})
+
+	for name, out := range outputs {
+		if out.count() != 1 {
+			t.Errorf("expected %s to receive the event, got %d", name, out.count())
+		}
+	}
+}
+
+func TestValidate_RejectsUnknownOutput(t *testing.T) {
+	err := router.Validate(config.RoutingConfig{
+		Routes: []config.RouteConfig{
+			{When: config.ConditionConfig{Field: "level", Equals: strPtr("error")}, Outputs: []string{"missing"}},
+		},
+	}, []string{"stdout"})
+	if err == nil {
+		t.Error("expected error for unknown output")
+	}
+}
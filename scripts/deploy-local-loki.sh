@@ -44,6 +44,7 @@ helm upgrade --install grafana grafana/grafana \
   --set datasources."datasources\.yaml".datasources[0].isDefault=true
 
 echo "Deploying flox..."
+kubectl apply -n "$NAMESPACE" -f manifests/flox-rbac.yaml
 kubectl apply -n "$NAMESPACE" -f manifests/flox-configmap.yaml
 kubectl apply -n "$NAMESPACE" -f manifests/flox-daemonset.yaml
 